@@ -0,0 +1,66 @@
+// Comando avulso de migração: lista empresas gravadas com o CNPJ fora da
+// forma canônica e grupos de empresas cujos CNPJs só diferem por pontuação.
+// Apenas reporta; nenhum documento é alterado.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"matriz/internal/config"
+	"matriz/internal/repository"
+	"matriz/internal/validation"
+)
+
+func main() {
+	cfg := config.Load()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() { _ = client.Disconnect(context.Background()) }()
+
+	// a coleção é lida diretamente: NewMongoEmpresaRepo migraria documentos e
+	// criaria índices
+	col := client.Database(cfg.MongoDB).Collection(cfg.MongoCollection)
+	rep, err := repository.ScanCNPJs(ctx, col)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("empresas analisadas: %d (%d excluídas, fora da busca por duplicidade)\n", rep.Total, rep.Deleted)
+	fmt.Printf("CNPJs fora da forma canônica: %d\n", len(rep.NonCanonical))
+	for _, e := range rep.NonCanonical {
+		excluida := ""
+		if e.DeletedAt != nil {
+			excluida = " (excluída)"
+		}
+		fmt.Printf("  id=%s cnpj=%q canônico=%s%s\n", e.ID, e.CNPJ, validation.NormalizeCNPJ(e.CNPJ), excluida)
+	}
+
+	keys := make([]string, 0, len(rep.Duplicates))
+	for k := range rep.Duplicates {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Printf("CNPJs duplicados: %d\n", len(keys))
+	for _, k := range keys {
+		fmt.Printf("  %s:\n", validation.FormatCNPJ(k))
+		for _, e := range rep.Duplicates[k] {
+			fmt.Printf("    id=%s cnpj=%q nome_fantasia=%q\n", e.ID, e.CNPJ, e.NomeFantasia)
+		}
+	}
+	if len(keys) > 0 {
+		os.Exit(1)
+	}
+}
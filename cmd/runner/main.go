@@ -16,8 +16,10 @@ func main() {
 		mustExec("/server")
 	case "wsserver":
 		mustExec("/wsserver")
+	case "cnpjdups":
+		mustExec("/cnpjdups")
 	default:
-		log.Fatalf("unknown command: %s (use 'server' or 'wsserver')", cmd)
+		log.Fatalf("unknown command: %s (use 'server', 'wsserver' or 'cnpjdups')", cmd)
 	}
 }
 
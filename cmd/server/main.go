@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
@@ -14,37 +15,129 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"matriz/internal/config"
+	"matriz/internal/events"
 	"matriz/internal/httpapi"
 	"matriz/internal/messaging"
+	"matriz/internal/outbox"
+	"matriz/internal/pcd"
 	"matriz/internal/repository"
 )
 
 func main() {
 	cfg := config.Load()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
-	if err != nil {
-		log.Fatal(err)
+	var opts []repository.Option
+	if cfg.OutboxEnabled {
+		opts = append(opts, repository.WithOutbox())
+	}
+	var repo repository.EmpresaStore
+	var box repository.Outbox
+	switch cfg.Store {
+	case "memory":
+		log.Printf("usando repositório em memória; os dados não serão persistidos")
+		memRepo := repository.NewMemoryEmpresaRepo(opts...)
+		repo, box = memRepo, memRepo
+	case "mongo":
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURI))
+		if err != nil {
+			log.Fatal(err)
+		}
+		mongoRepo, err := repository.NewMongoEmpresaRepo(client, cfg.MongoDB, cfg.MongoCollection, opts...)
+		if err != nil {
+			log.Fatal(err)
+		}
+		repo, box = mongoRepo, mongoRepo
+	default:
+		log.Fatalf("EMPRESA_STORE inválido: %q (use mongo ou memory)", cfg.Store)
 	}
 
-	repo, err := repository.NewMongoEmpresaRepo(client, cfg.MongoDB, cfg.MongoCollection)
-	if err != nil {
-		log.Fatal(err)
+	// openPublisher abre o EventPublisher escolhido em EVENT_PUBLISHER (nil
+	// com none). Para o relay do outbox, o RabbitMQ não usa buffer e a
+	// abertura falha se ele estiver indisponível: as novas tentativas ficam
+	// com o relay, e um evento só é marcado como entregue depois de chegar ao
+	// broker. Sem outbox, os handlers publicam diretamente e o publisher
+	// guarda os eventos em memória enquanto o RabbitMQ estiver fora.
+	openPublisher := func(forRelay bool) (messaging.EventPublisher, error) { return nil, nil }
+	switch cfg.EventPublisher {
+	case "rabbit":
+		mode, err := messaging.ParseMode(cfg.CloudEventsMode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pubOpts := []messaging.Option{
+			messaging.WithMode(mode),
+			messaging.WithSource(cfg.CloudEventsSource),
+			messaging.WithMandatory(cfg.RabbitMandatory),
+		}
+		openPublisher = func(forRelay bool) (messaging.EventPublisher, error) {
+			if !forRelay {
+				return messaging.NewPublisher(cfg.RabbitURL, cfg.RabbitExchange, pubOpts...), nil
+			}
+			p := messaging.NewPublisher(cfg.RabbitURL, cfg.RabbitExchange, append(pubOpts, messaging.WithBufferSize(0))...)
+			if p.State() != messaging.StateConnected {
+				p.Close()
+				return nil, errors.New("RabbitMQ indisponível")
+			}
+			return p, nil
+		}
+	case "channel":
+		broker := messaging.NewChannelBroker()
+		sub, _ := broker.Subscribe(subscriberBuffer)
+		go logEvents(sub)
+		openPublisher = func(forRelay bool) (messaging.EventPublisher, error) {
+			if forRelay {
+				return keepOpen{broker}, nil
+			}
+			return broker, nil
+		}
+	case "log":
+		openPublisher = func(bool) (messaging.EventPublisher, error) { return messaging.NewLogPublisher(nil), nil }
+	case "none":
+		if cfg.OutboxEnabled {
+			log.Fatal("EVENT_PUBLISHER=none não pode ser usado com OUTBOX_ENABLED=true")
+		}
+		log.Printf("EVENT_PUBLISHER=none: os eventos não serão publicados")
+	default:
+		log.Fatalf("EVENT_PUBLISHER inválido: %q (use rabbit, channel, log ou none)", cfg.EventPublisher)
 	}
 
-	pub, err := messaging.NewPublisher(cfg.RabbitURL, "logs.empresas")
-	if err != nil {
-		log.Printf("RabbitMQ indisponível: %v", err)
+	var pub messaging.EventPublisher
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	if cfg.OutboxEnabled {
+		relay := outbox.NewRelay(box, func() (outbox.Publisher, error) { return openPublisher(true) })
+		go func() {
+			relay.Run(relayCtx)
+			close(relayDone)
+		}()
+	} else {
+		close(relayDone)
+		var err error
+		if pub, err = openPublisher(false); err != nil {
+			log.Fatal(err)
+		}
 	}
 	defer func() {
+		stopRelay()
+		<-relayDone
 		if pub != nil {
 			pub.Close()
 		}
 	}()
 
-	api := httpapi.NewServer(repo, pub)
+	policy, err := pcd.ParsePolicy(cfg.PCDPolicy)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	api := httpapi.NewServer(repo, pub,
+		httpapi.WithPCDPolicy(policy),
+		httpapi.WithRequireIfMatch(cfg.RequireIfMatch),
+		httpapi.WithAdminToken(cfg.AdminToken),
+		httpapi.WithPurgeRetention(cfg.PurgeRetention),
+	)
 	r := chi.NewRouter()
 	r.Mount("/api", api.Routes())
 
@@ -63,3 +156,24 @@ func main() {
 	defer cancelShut()
 	_ = srv.Shutdown(ctxShut)
 }
+
+// subscriberBuffer é o buffer do consumidor em processo de
+// EVENT_PUBLISHER=channel.
+const subscriberBuffer = 100
+
+// logEvents é o consumidor em processo de EVENT_PUBLISHER=channel: registra
+// no log cada evento recebido, até o canal ser fechado.
+func logEvents(ch <-chan events.Event) {
+	for ev := range ch {
+		log.Printf("evento %s %s: %s", ev.Type, ev.ID, ev.Message)
+	}
+}
+
+// keepOpen repassa as publicações ao ChannelBroker e ignora Close: o relay
+// fecha o publisher a cada falha, mas o broker e o consumidor inscrito nele
+// vivem enquanto o processo rodar.
+type keepOpen struct {
+	*messaging.ChannelBroker
+}
+
+func (keepOpen) Close() {}
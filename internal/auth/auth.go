@@ -0,0 +1,21 @@
+// Package auth transporta no context.Context a identidade de quem faz a
+// requisição (o principal), para que camadas internas, como o repositório,
+// possam registrá-la sem depender do mecanismo de autenticação HTTP.
+package auth
+
+import "context"
+
+type principalKey struct{}
+
+// WithPrincipal devolve uma cópia de ctx associada ao principal id (ex.: o
+// login ou o subject do token já autenticado).
+func WithPrincipal(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, principalKey{}, id)
+}
+
+// PrincipalFrom devolve o principal associado a ctx, ou "" se a requisição
+// não foi autenticada.
+func PrincipalFrom(ctx context.Context) string {
+	id, _ := ctx.Value(principalKey{}).(string)
+	return id
+}
@@ -0,0 +1,111 @@
+// Package events define os eventos de domínio publicados a cada alteração
+// de empresa, serializados em JSON para os consumidores do RabbitMQ.
+package events
+
+import (
+	"fmt"
+	"time"
+
+	"matriz/internal/models"
+	"matriz/internal/pcd"
+	"matriz/internal/repository"
+)
+
+// ContentType é o tipo de conteúdo dos eventos serializados.
+const ContentType = "application/json"
+
+// SchemaVersion é a versão do formato de Event. Muda apenas em alterações
+// incompatíveis; campos novos podem ser acrescentados na mesma versão.
+const SchemaVersion = 1
+
+// Tipos de evento.
+const (
+	EmpresaCriada     = "EmpresaCriada"
+	EmpresaAtualizada = "EmpresaAtualizada"
+	EmpresaExcluida   = "EmpresaExcluida"
+	EmpresaRestaurada = "EmpresaRestaurada"
+	EmpresaExpurgada  = "EmpresaExpurgada"
+)
+
+// Event é um evento de domínio sobre uma empresa (o agregado). ID é
+// determinístico (ID e versão da empresa), então uma reentrega do mesmo
+// evento tem o mesmo ID e pode ser descartada pelo consumidor. Payload é a
+// empresa completa após a alteração (nil no expurgo, que apaga os dados),
+// com os campos PCD derivados calculados como nas respostas da API, e
+// Message o texto legível exibido no feed do WebSocket.
+type Event struct {
+	ID               string          `json:"id"`
+	Type             string          `json:"type"`
+	SchemaVersion    int             `json:"schema_version"`
+	OccurredAt       time.Time       `json:"occurred_at"`
+	AggregateID      string          `json:"aggregate_id"`
+	AggregateVersion int64           `json:"aggregate_version"`
+	CNPJ             string          `json:"cnpj"`
+	Actor            string          `json:"actor,omitempty"`
+	ChangedFields    []string        `json:"changed_fields"`
+	Payload          *models.Empresa `json:"payload"`
+	Message          string          `json:"message"`
+}
+
+// FromHistory monta o evento da alteração registrada em h, que deve trazer
+// o snapshot (veja repository.EmpresaStore.HistoryVersion).
+func FromHistory(h repository.HistoryEntry) Event {
+	ev := Event{
+		ID:               fmt.Sprintf("%s-%d", h.EmpresaID, h.Version),
+		Type:             eventType(h.Op),
+		SchemaVersion:    SchemaVersion,
+		OccurredAt:       h.At,
+		AggregateID:      h.EmpresaID,
+		AggregateVersion: h.Version,
+		Actor:            h.By,
+		ChangedFields:    make([]string, 0, len(h.Changes)),
+	}
+	if h.Snapshot != nil {
+		// cópia: o snapshot pode ser compartilhado com o repositório
+		payload := *h.Snapshot
+		pcd.Preencher(&payload)
+		ev.Payload = &payload
+	}
+	for _, c := range h.Changes {
+		ev.ChangedFields = append(ev.ChangedFields, c.Field)
+	}
+	nome := h.EmpresaID
+	if h.Snapshot != nil {
+		ev.CNPJ, nome = h.Snapshot.CNPJ, h.Snapshot.NomeFantasia
+	}
+	ev.Message = message(ev.Type, nome)
+	return ev
+}
+
+// eventType traduz a operação do histórico no tipo do evento.
+func eventType(op string) string {
+	switch op {
+	case repository.OpCreate:
+		return EmpresaCriada
+	case repository.OpDelete:
+		return EmpresaExcluida
+	case repository.OpRestore:
+		return EmpresaRestaurada
+	case repository.OpPurge:
+		return EmpresaExpurgada
+	default:
+		return EmpresaAtualizada
+	}
+}
+
+// message devolve o texto legível do evento, no formato das mensagens de
+// texto publicadas antes dos eventos estruturados.
+func message(typ, nome string) string {
+	switch typ {
+	case EmpresaCriada:
+		return "Cadastro de EMPRESA " + nome
+	case EmpresaExcluida:
+		return "Exclusão da EMPRESA " + nome
+	case EmpresaRestaurada:
+		return "Restauração da EMPRESA " + nome
+	case EmpresaExpurgada:
+		return "Expurgo da EMPRESA " + nome
+	default:
+		return "Edição da EMPRESA " + nome
+	}
+}
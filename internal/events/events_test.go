@@ -0,0 +1,103 @@
+package events
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"matriz/internal/models"
+	"matriz/internal/repository"
+)
+
+func TestFromHistory(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	h := repository.HistoryEntry{
+		EmpresaID: "665f1c2b8a1e4d0012345678",
+		Version:   3,
+		Op:        repository.OpUpdate,
+		At:        at,
+		By:        "maria",
+		Changes: []repository.FieldChange{
+			{Field: "nome_fantasia", Before: "Padaria", After: "Padaria Nova"},
+			{Field: "endereco", Before: "Rua A", After: "Rua B"},
+		},
+		Snapshot: &models.Empresa{ID: "665f1c2b8a1e4d0012345678", CNPJ: "04252011000110", NomeFantasia: "Padaria Nova",
+			NumFuncionarios: 2000, NumMinPCD: 100, NumPCDContratados: 10, Version: 3},
+	}
+	ev := FromHistory(h)
+	// o payload traz os campos PCD derivados, sem alterar o snapshot
+	payload := *h.Snapshot
+	payload.CotaLegalPCD, payload.StatusPCD, payload.DeficitPCD = 100, "nao_conforme", 90
+	want := Event{
+		ID:               "665f1c2b8a1e4d0012345678-3",
+		Type:             EmpresaAtualizada,
+		SchemaVersion:    SchemaVersion,
+		OccurredAt:       at,
+		AggregateID:      h.EmpresaID,
+		AggregateVersion: 3,
+		CNPJ:             "04252011000110",
+		Actor:            "maria",
+		ChangedFields:    []string{"nome_fantasia", "endereco"},
+		Payload:          &payload,
+		Message:          "Edição da EMPRESA Padaria Nova",
+	}
+	if !reflect.DeepEqual(ev, want) {
+		t.Errorf("FromHistory = %+v, want %+v", ev, want)
+	}
+	if ev.Payload == h.Snapshot || h.Snapshot.StatusPCD != "" {
+		t.Errorf("FromHistory alterou o snapshot: %+v", h.Snapshot)
+	}
+}
+
+func TestFromHistoryTypes(t *testing.T) {
+	snap := &models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Padaria"}
+	cases := []struct {
+		op, typ, msg string
+	}{
+		{repository.OpCreate, EmpresaCriada, "Cadastro de EMPRESA Padaria"},
+		{repository.OpUpdate, EmpresaAtualizada, "Edição da EMPRESA Padaria"},
+		{repository.OpDelete, EmpresaExcluida, "Exclusão da EMPRESA Padaria"},
+		{repository.OpRestore, EmpresaRestaurada, "Restauração da EMPRESA Padaria"},
+	}
+	for _, c := range cases {
+		ev := FromHistory(repository.HistoryEntry{EmpresaID: "x", Version: 1, Op: c.op, Snapshot: snap})
+		if ev.Type != c.typ || ev.Message != c.msg {
+			t.Errorf("op %s: tipo %q e mensagem %q, want %q e %q", c.op, ev.Type, ev.Message, c.typ, c.msg)
+		}
+	}
+	// o expurgo não tem snapshot: a mensagem usa o ID da empresa
+	ev := FromHistory(repository.HistoryEntry{EmpresaID: "x", Version: 4, Op: repository.OpPurge})
+	if ev.Type != EmpresaExpurgada || ev.Message != "Expurgo da EMPRESA x" || ev.Payload != nil || ev.CNPJ != "" {
+		t.Errorf("expurgo = %+v", ev)
+	}
+}
+
+func TestEventJSON(t *testing.T) {
+	ev := FromHistory(repository.HistoryEntry{
+		EmpresaID: "x",
+		Version:   1,
+		Op:        repository.OpCreate,
+		Snapshot:  &models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Padaria"},
+	})
+	raw, err := json.Marshal(ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"id", "type", "schema_version", "occurred_at", "aggregate_id",
+		"aggregate_version", "cnpj", "changed_fields", "payload", "message"} {
+		if _, ok := got[k]; !ok {
+			t.Errorf("campo %q ausente em %s", k, raw)
+		}
+	}
+	if _, ok := got["actor"]; ok {
+		t.Errorf("actor vazio não deveria ser serializado: %s", raw)
+	}
+	if fields, ok := got["changed_fields"].([]interface{}); !ok || len(fields) != 0 {
+		t.Errorf("changed_fields = %v, want lista vazia", got["changed_fields"])
+	}
+}
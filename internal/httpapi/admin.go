@@ -0,0 +1,51 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"matriz/internal/repository"
+)
+
+// DefaultPurgeRetention é o tempo mínimo, desde a exclusão, para que uma
+// empresa excluída seja expurgada (30 dias).
+const DefaultPurgeRetention = 30 * 24 * time.Hour
+
+// requireAdmin protege os endpoints administrativos com o header
+// X-Admin-Token: responde 403 se nenhum token foi configurado no servidor e
+// 401 se o token enviado não confere.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			writeError(w, http.StatusForbidden, "endpoints administrativos desabilitados")
+			return
+		}
+		token := r.Header.Get("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			writeError(w, http.StatusUnauthorized, "token administrativo inválido")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// purge trata POST /admin/empresas/purge, removendo definitivamente as
+// empresas excluídas há mais tempo que o período de retenção, e publica um
+// evento EmpresaExpurgada para cada uma se Publisher estiver configurado.
+// Status:
+// - 200 com {"purged": <quantidade>}.
+// - 401 se X-Admin-Token não conferir.
+// - 403 se não houver token administrativo configurado.
+// - 503 se o repositório estiver indisponível.
+func (s *Server) purge(w http.ResponseWriter, r *http.Request) {
+	ids, err := s.repo.Purge(r.Context(), time.Now().Add(-s.purgeRetention))
+	for _, id := range ids {
+		s.publishLast(r.Context(), id, repository.OpPurge)
+	}
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"purged": len(ids)})
+}
@@ -0,0 +1,117 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"matriz/internal/models"
+	"matriz/internal/repository"
+)
+
+// etag devolve a ETag (forte) de uma versão de empresa.
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// etagMatches aplica a comparação forte de If-Match (RFC 9110, 13.1.1) entre
+// a lista do header e a ETag atual; "*" casa com qualquer versão.
+func etagMatches(header, current string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// ifMatch avalia o If-Match de uma escrita em id e devolve a versão a
+// exigir do repositório: a versão atual, se o header casou, ou
+// repository.AnyVersion se ele não foi enviado. cur, se não for nil, é a
+// versão já lida pelo handler. Responde 428 se o header for obrigatório e
+// estiver ausente, 412 se não casar ou o erro do repositório; nesses casos
+// ok é false.
+func (s *Server) ifMatch(w http.ResponseWriter, r *http.Request, id string, cur *models.Empresa) (version int64, ok bool) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		if s.requireIfMatch {
+			writeError(w, http.StatusPreconditionRequired, "header If-Match obrigatório")
+			return 0, false
+		}
+		return repository.AnyVersion, true
+	}
+	if cur == nil {
+		var err error
+		if cur, err = s.repo.Get(r.Context(), id); err != nil {
+			writeRepoError(w, err)
+			return 0, false
+		}
+	}
+	if !etagMatches(header, etag(cur.Version)) {
+		w.Header().Set("ETag", etag(cur.Version))
+		writeError(w, http.StatusPreconditionFailed, repository.ErrVersionConflict.Error())
+		return 0, false
+	}
+	return cur.Version, true
+}
+
+// cacheHeaders grava os validadores da resposta (ETag e, se conhecido,
+// Last-Modified) e exige revalidação a cada uso, já que os dados mudam a
+// qualquer momento.
+func cacheHeaders(w http.ResponseWriter, tag string, modified time.Time) {
+	h := w.Header()
+	h.Set("Cache-Control", "no-cache")
+	h.Set("ETag", tag)
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified avalia If-None-Match e If-Modified-Since de um GET (RFC 9110,
+// 13.2.2) e, se a representação do cliente ainda é válida, responde 304 e
+// devolve true. If-Modified-Since só é considerado sem If-None-Match.
+func notModified(w http.ResponseWriter, r *http.Request, tag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !weakMatches(inm, tag) {
+			return false
+		}
+	} else {
+		ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+		// Last-Modified tem precisão de segundos
+		if err != nil || modified.IsZero() || modified.Truncate(time.Second).After(ims) {
+			return false
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// weakMatches aplica a comparação fraca de If-None-Match: ignora o prefixo
+// W/ e "*" casa com qualquer representação.
+func weakMatches(header, current string) bool {
+	current = strings.TrimPrefix(current, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == current {
+			return true
+		}
+	}
+	return false
+}
+
+// listETag identifica uma página de GET /empresas pelos IDs e versões dos
+// itens, o cursor seguinte e o total: qualquer escrita que altere a página
+// muda a ETag.
+func listETag(res *repository.ListResult) string {
+	h := sha256.New()
+	for _, e := range res.Items {
+		fmt.Fprintf(h, "%s:%d;", e.ID, e.Version)
+	}
+	fmt.Fprintf(h, "%s;%d", res.NextCursor, res.Total)
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
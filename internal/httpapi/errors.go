@@ -0,0 +1,48 @@
+package httpapi
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"matriz/internal/repository"
+)
+
+// repoErrorStatus mapeia os erros de repository para status HTTP.
+func repoErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrDuplicateCNPJ):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrVersionConflict):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, repository.ErrInvalidID), errors.Is(err, repository.ErrInvalidQuery),
+		errors.Is(err, repository.ErrInvalidChange):
+		return http.StatusBadRequest
+	case errors.Is(err, repository.ErrUnavailable):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeRepoError responde a um erro do repositório com o status de
+// repoErrorStatus. Detalhes de falhas de infraestrutura (503/500) vão apenas
+// para o log.
+func writeRepoError(w http.ResponseWriter, err error) {
+	status := repoErrorStatus(err)
+	switch status {
+	case http.StatusNotFound:
+		writeError(w, status, "não encontrado")
+	case http.StatusServiceUnavailable:
+		log.Printf("repositório indisponível: %v", err)
+		w.Header().Set("Retry-After", "5")
+		writeError(w, status, "serviço temporariamente indisponível")
+	case http.StatusInternalServerError:
+		log.Printf("erro no repositório: %v", err)
+		writeError(w, status, "erro interno")
+	default:
+		writeError(w, status, err.Error())
+	}
+}
@@ -2,28 +2,55 @@ package httpapi
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"mime"
 	"net/http"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"matriz/internal/messaging"
 	"matriz/internal/models"
+	"matriz/internal/pcd"
 	"matriz/internal/repository"
 	"matriz/internal/validation"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// maxJSONBody limita o tamanho de corpos application/json (1MB).
+const maxJSONBody = 1 << 20
+
+// empresaInput é o corpo JSON aceito em POST/PUT /empresas (veja
+// decodeEmpresa) e o documento sobre o qual PATCH é aplicado.
+type empresaInput struct {
+	CNPJ              string `json:"cnpj"`
+	NomeFantasia      string `json:"nome_fantasia"`
+	RazaoSocial       string `json:"razao_social"`
+	Endereco          string `json:"endereco"`
+	NumFuncionarios   int    `json:"num_funcionarios"`
+	NumMinPCD         int    `json:"num_min_pcd"`
+	NumPCDContratados int    `json:"num_pcd_contratados"`
+}
+
 // parseEmpresaFromRequest converte o corpo da requisição em models.Empresa,
-// suporta application/x-www-form-urlencoded e multipart/form-data.
-// Limite de memória para multipart: ~10MB.
-// Retorna http.ErrNotSupported quando o Content-Type não é suportado.
-func parseEmpresaFromRequest(r *http.Request) (models.Empresa, error) {
+// suporta application/json, application/x-www-form-urlencoded e
+// multipart/form-data.
+// Limite de memória para multipart: ~10MB; para JSON: 1MB.
+// Retorna http.ErrNotSupported quando o Content-Type não é suportado e
+// validation.Errors (junto com os demais campos lidos) quando um campo
+// numérico não pôde ser convertido.
+func parseEmpresaFromRequest(w http.ResponseWriter, r *http.Request) (models.Empresa, error) {
 	var e models.Empresa
 	ct := r.Header.Get("Content-Type")
 	mediatype, _, _ := mime.ParseMediaType(ct)
 	switch strings.ToLower(mediatype) {
+	case "application/json":
+		return decodeEmpresa(http.MaxBytesReader(w, r.Body, maxJSONBody))
 	case "application/x-www-form-urlencoded", "multipart/form-data", "":
 		// Parse de form values. Para multipart, ParseMultipartForm; para urlencoded, ParseForm.
 		if strings.HasPrefix(strings.ToLower(mediatype), "multipart/") {
@@ -36,15 +63,12 @@ func parseEmpresaFromRequest(r *http.Request) (models.Empresa, error) {
 		e.NomeFantasia = strings.TrimSpace(form.Get("nome_fantasia"))
 		e.RazaoSocial = strings.TrimSpace(form.Get("razao_social"))
 		e.Endereco = strings.TrimSpace(form.Get("endereco"))
-		if v := strings.TrimSpace(form.Get("num_funcionarios")); v != "" {
-			if n, err := strconv.Atoi(v); err == nil {
-				e.NumFuncionarios = n
-			}
-		}
-		if v := strings.TrimSpace(form.Get("num_min_pcd")); v != "" {
-			if n, err := strconv.Atoi(v); err == nil {
-				e.NumMinPCD = n
-			}
+		var campos validation.Errors
+		e.NumFuncionarios = formInt(form.Get("num_funcionarios"), "num_funcionarios", &campos)
+		e.NumMinPCD = formInt(form.Get("num_min_pcd"), "num_min_pcd", &campos)
+		e.NumPCDContratados = formInt(form.Get("num_pcd_contratados"), "num_pcd_contratados", &campos)
+		if len(campos) > 0 {
+			return e, campos
 		}
 		return e, nil
 	default:
@@ -52,34 +76,267 @@ func parseEmpresaFromRequest(r *http.Request) (models.Empresa, error) {
 	}
 }
 
+// formInt converte um campo numérico de formulário. Valores vazios resultam
+// em 0; valores não numéricos são registrados em campos.
+func formInt(v, field string, campos *validation.Errors) int {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		campos.Add(field, "deve ser um número inteiro")
+		return 0
+	}
+	return n
+}
+
+// decodeEmpresa lê um único objeto empresaInput de body, rejeitando campos
+// desconhecidos e conteúdo após o objeto. Cada campo é convertido
+// separadamente, para que todos os de tipo incorreto sejam devolvidos como
+// validation.Errors, junto com os demais campos lidos.
+func decodeEmpresa(body io.Reader) (models.Empresa, error) {
+	var e models.Empresa
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(body)
+	if err := dec.Decode(&raw); err != nil {
+		return e, jsonError(err)
+	}
+	if dec.More() {
+		return e, errors.New("json inválido: conteúdo após o objeto")
+	}
+	fields := []struct {
+		name string
+		dst  interface{}
+	}{
+		{"cnpj", &e.CNPJ},
+		{"nome_fantasia", &e.NomeFantasia},
+		{"razao_social", &e.RazaoSocial},
+		{"endereco", &e.Endereco},
+		{"num_funcionarios", &e.NumFuncionarios},
+		{"num_min_pcd", &e.NumMinPCD},
+		{"num_pcd_contratados", &e.NumPCDContratados},
+	}
+	var campos validation.Errors
+	for _, f := range fields {
+		msg, ok := raw[f.name]
+		if !ok {
+			continue
+		}
+		delete(raw, f.name)
+		if err := json.Unmarshal(msg, f.dst); err != nil {
+			campos.Add(f.name, "deve ser do tipo "+jsonTypeName(reflect.TypeOf(f.dst).Elem().Kind()))
+		}
+	}
+	// o que sobrou em raw não é campo de empresaInput
+	if len(raw) > 0 {
+		unknown := make([]string, 0, len(raw))
+		for name := range raw {
+			unknown = append(unknown, name)
+		}
+		sort.Strings(unknown)
+		return e, fmt.Errorf("json inválido: campo desconhecido %q", unknown[0])
+	}
+	e.CNPJ = strings.TrimSpace(e.CNPJ)
+	e.NomeFantasia = strings.TrimSpace(e.NomeFantasia)
+	e.RazaoSocial = strings.TrimSpace(e.RazaoSocial)
+	e.Endereco = strings.TrimSpace(e.Endereco)
+	if len(campos) > 0 {
+		return e, campos
+	}
+	return e, nil
+}
+
+// jsonError traduz erros de encoding/json em mensagens para o cliente.
+func jsonError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field == "":
+		return errors.New("json inválido: o corpo deve ser um objeto")
+	case errors.As(err, &typeErr):
+		return fmt.Errorf("json inválido: campo %q deve ser do tipo %s", typeErr.Field, typeErr.Type)
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("json inválido: erro de sintaxe na posição %d", syntaxErr.Offset)
+	case errors.As(err, &maxErr):
+		return fmt.Errorf("json inválido: corpo maior que %d bytes", maxErr.Limit)
+	case errors.Is(err, io.EOF):
+		return errors.New("json inválido: corpo vazio")
+	default:
+		return fmt.Errorf("json inválido: %v", err)
+	}
+}
+
+// jsonTypeName descreve em português o tipo esperado de um campo JSON.
+func jsonTypeName(k reflect.Kind) string {
+	switch k {
+	case reflect.Int, reflect.Int64:
+		return "número inteiro"
+	case reflect.String:
+		return "texto"
+	default:
+		return k.String()
+	}
+}
+
+// writeParseError responde a falhas de parseEmpresaFromRequest: 415 para
+// Content-Type não suportado e 400 para corpos malformados.
+func writeParseError(w http.ResponseWriter, err error) {
+	if errors.Is(err, http.ErrNotSupported) {
+		writeError(w, http.StatusUnsupportedMediaType, "content-type não suportado")
+		return
+	}
+	writeError(w, http.StatusBadRequest, err.Error())
+}
+
+// readEmpresa faz o parse e a validação do corpo de POST/PUT /empresas e
+// devolve a empresa com o CNPJ já normalizado e num_min_pcd tratado conforme
+// a política de cota PCD, junto com eventuais avisos. Em caso de falha a
+// resposta de erro já foi escrita e ok é false.
+func (s *Server) readEmpresa(w http.ResponseWriter, r *http.Request) (e models.Empresa, avisos []string, ok bool) {
+	e, err := parseEmpresaFromRequest(w, r)
+	var campos validation.Errors
+	if err != nil && !errors.As(err, &campos) {
+		writeParseError(w, err)
+		return e, nil, false
+	}
+	avisos, ok = s.checkEmpresa(w, &e, campos)
+	return e, avisos, ok
+}
+
+// checkEmpresa valida uma empresa lida da requisição (campos traz os erros
+// de conversão já encontrados): responde 422 com todos os erros de campo,
+// inclusive o de CNPJ inválido. Normaliza o CNPJ e aplica a política de cota
+// PCD.
+func (s *Server) checkEmpresa(w http.ResponseWriter, e *models.Empresa, campos validation.Errors) (avisos []string, ok bool) {
+	if err := validation.ValidateCNPJ(e.CNPJ); err != nil {
+		campos.Add("cnpj", err.Error())
+	} else {
+		e.CNPJ = validation.NormalizeCNPJ(e.CNPJ)
+	}
+	if !campos.Has("num_funcionarios") && !campos.Has("num_min_pcd") {
+		avisos = s.applyPCDPolicy(e, &campos)
+	}
+	if campos = validation.ValidateEmpresa(e, campos); len(campos) > 0 {
+		writeValidationError(w, campos)
+		return nil, false
+	}
+	return avisos, true
+}
+
+// applyPCDPolicy trata num_min_pcd conforme s.pcdPolicy: calcula a cota,
+// registra erro de campo se divergir ou devolve um aviso.
+func (s *Server) applyPCDPolicy(e *models.Empresa, campos *validation.Errors) []string {
+	if e.NumFuncionarios < 0 {
+		return nil
+	}
+	cota := pcd.CotaMinima(e.NumFuncionarios)
+	switch s.pcdPolicy {
+	case pcd.PolicyValidate:
+		if e.NumMinPCD != cota {
+			campos.Add("num_min_pcd", fmt.Sprintf("deve ser %d conforme art. 93 da Lei 8.213/91", cota))
+		}
+	case pcd.PolicyWarn:
+		if e.NumMinPCD != cota {
+			return []string{fmt.Sprintf("num_min_pcd informado (%d) difere da cota legal (%d) conforme art. 93 da Lei 8.213/91", e.NumMinPCD, cota)}
+		}
+	default:
+		e.NumMinPCD = cota
+	}
+	return nil
+}
+
 type Server struct {
-	repo repository.EmpresaStore
-	pub  *messaging.Publisher
+	repo           repository.EmpresaStore
+	pub            messaging.EventPublisher
+	pcdPolicy      pcd.Policy
+	requireIfMatch bool
+	adminToken     string
+	purgeRetention time.Duration
+}
+
+// Option configura um Server em NewServer.
+type Option func(*Server)
+
+// WithPCDPolicy define como num_min_pcd é tratado em create/update.
+// O padrão é pcd.PolicyCompute.
+func WithPCDPolicy(p pcd.Policy) Option {
+	return func(s *Server) { s.pcdPolicy = p }
+}
+
+// WithRequireIfMatch exige o header If-Match em PUT, PATCH e DELETE,
+// respondendo 428 quando ausente. Por padrão ele é opcional.
+func WithRequireIfMatch(require bool) Option {
+	return func(s *Server) { s.requireIfMatch = require }
+}
+
+// WithAdminToken define o token exigido no header X-Admin-Token pelos
+// endpoints administrativos. Sem token eles ficam desabilitados.
+func WithAdminToken(token string) Option {
+	return func(s *Server) { s.adminToken = token }
+}
+
+// WithPurgeRetention define por quanto tempo empresas excluídas são mantidas
+// antes de poderem ser expurgadas. O padrão é DefaultPurgeRetention.
+func WithPurgeRetention(d time.Duration) Option {
+	return func(s *Server) { s.purgeRetention = d }
 }
 
 // NewServer cria uma instância do servidor HTTP com as dependências de
-// repositório e publisher (opcional). Se pub for nil, eventos não serão publicados.
-func NewServer(repo repository.EmpresaStore, pub *messaging.Publisher) *Server {
-	return &Server{repo: repo, pub: pub}
+// repositório e publisher de eventos (opcional). Se pub for nil, os handlers não publicam
+// eventos, como quando o repositório usa o outbox (repository.WithOutbox).
+func NewServer(repo repository.EmpresaStore, pub messaging.EventPublisher, opts ...Option) *Server {
+	s := &Server{repo: repo, pub: pub, pcdPolicy: pcd.PolicyCompute, purgeRetention: DefaultPurgeRetention}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Routes registra e retorna as rotas HTTP do serviço de empresas.
 // Endpoints:
 // - POST   /empresas
 // - GET    /empresas
+// - GET    /empresas/compliance
+// - GET    /empresas/search
 // - GET    /empresas/{id}
 // - PUT    /empresas/{id}
+// - PATCH  /empresas/{id}
 // - DELETE /empresas/{id}
+// - POST   /empresas/{id}/restore
+// - GET    /empresas/{id}/history
+// - GET    /empresas/{id}/history/{version}
+// - POST   /admin/empresas/purge
+// Erros do repositório são mapeados por repoErrorStatus: 404 (não encontrado),
+// 409 (CNPJ duplicado), 412 (versão desatualizada), 400 (ID ou consulta
+// inválidos) e 503 (indisponível).
+// GET /empresas/{id} devolve a versão da empresa como ETag; PUT, PATCH e
+// DELETE aceitam If-Match com essa ETag (veja ifMatch).
 func (s *Server) Routes() chi.Router {
 	r := chi.NewRouter()
 	r.Post("/empresas", s.create)
 	r.Get("/empresas", s.list)
+	r.Get("/empresas/compliance", s.compliance)
+	r.Get("/empresas/search", s.search)
 	r.Get("/empresas/{id}", s.get)
 	r.Put("/empresas/{id}", s.update)
+	r.Patch("/empresas/{id}", s.patch)
 	r.Delete("/empresas/{id}", s.delete)
+	r.Post("/empresas/{id}/restore", s.restore)
+	r.Get("/empresas/{id}/history", s.history)
+	r.Get("/empresas/{id}/history/{version}", s.historyVersion)
+	r.With(s.requireAdmin).Post("/admin/empresas/purge", s.purge)
 	return r
 }
 
+// apresentar prepara uma empresa para a resposta JSON, preenchendo os campos
+// derivados que não são persistidos.
+func apresentar(e *models.Empresa) {
+	e.CNPJFormatado = validation.FormatCNPJ(e.CNPJ)
+	pcd.Preencher(e)
+}
+
 // writeJSON escreve uma resposta JSON com o status informado.
 // Se v for nil, apenas os headers e o status são enviados.
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
@@ -90,6 +347,15 @@ func writeJSON(w http.ResponseWriter, status int, v interface{}) {
 	}
 }
 
+// writeValidationError responde 422 listando os erros de cada campo no
+// formato {"error": "dados inválidos", "fields": [{"field": "...", "message": "..."}]}.
+func writeValidationError(w http.ResponseWriter, campos validation.Errors) {
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"error":  "dados inválidos",
+		"fields": campos,
+	})
+}
+
 // writeError padroniza respostas de erro no formato {"error": "..."}.
 func writeError(w http.ResponseWriter, status int, msg string) {
 	writeJSON(w, status, map[string]string{"error": msg})
@@ -97,103 +363,293 @@ func writeError(w http.ResponseWriter, status int, msg string) {
 
 // create trata POST /empresas.
 // Status:
-// - 201 em caso de sucesso (retorna {"id": "<novo_id>"}).
-// - 400 para erros de validação/negócio (ex.: "cnpj já cadastrado").
-// publica mensagem de "Cadastro de EMPRESA ..." se Publisher estiver configurado.
+// - 201 em caso de sucesso (retorna {"id": "<novo_id>"}, com "warnings" se houver avisos,
+// e a ETag da versão criada).
+// - 400 para corpo malformado.
+// - 409 se o CNPJ já estiver cadastrado.
+// - 415 para Content-Type não suportado.
+// - 422 com a lista de campos inválidos, inclusive CNPJ.
+// publica o evento EmpresaCriada se Publisher estiver configurado.
 func (s *Server) create(w http.ResponseWriter, r *http.Request) {
-	e, err := parseEmpresaFromRequest(r)
+	e, avisos, ok := s.readEmpresa(w, r)
+	if !ok {
+		return
+	}
+	id, err := s.repo.Create(r.Context(), &e)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "form inválido")
+		writeRepoError(w, err)
 		return
 	}
-	if err := validation.ValidateCNPJ(e.CNPJ); err != nil {
+	s.publish(r.Context(), id, e.Version)
+	resp := map[string]interface{}{"id": id}
+	if len(avisos) > 0 {
+		resp["warnings"] = avisos
+	}
+	w.Header().Set("ETag", etag(e.Version))
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// list trata GET /empresas.
+// Parâmetros de consulta (todos opcionais):
+// - limit: tamanho da página (padrão 50, máximo 500)
+// - cursor: next_cursor da página anterior
+// - sort: id, cnpj, nome_fantasia, razao_social ou num_funcionarios ("-" para decrescente)
+// - nome_fantasia: prefixo do nome fantasia
+// - razao_social: trecho da razão social
+// - min_funcionarios, max_funcionarios: faixa de num_funcionarios
+// Status:
+// - 200 com {"items": [...], "next_cursor": "<cursor>"|null, "total": <n>} e
+// a ETag da página.
+// - 304 se If-None-Match corresponder à ETag da página.
+// - 400 para parâmetros inválidos.
+// - 500 em caso de falha no repositório.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	q, err := parseListQuery(r)
+	if err != nil {
 		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	id, err := s.repo.Create(r.Context(), &e)
+	res, err := s.repo.List(r.Context(), q)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+		writeRepoError(w, err)
+		return
+	}
+	tag := listETag(res)
+	cacheHeaders(w, tag, time.Time{})
+	if notModified(w, r, tag, time.Time{}) {
 		return
 	}
-	if s.pub != nil {
-		_ = s.pub.Publish("Cadastro de EMPRESA " + e.NomeFantasia)
+	for i := range res.Items {
+		apresentar(&res.Items[i])
 	}
-	writeJSON(w, http.StatusCreated, map[string]string{"id": id})
+	var next interface{}
+	if res.NextCursor != "" {
+		next = res.NextCursor
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"items":       res.Items,
+		"next_cursor": next,
+		"total":       res.Total,
+	})
 }
 
-// list trata GET /empresas.
+// parseListQuery lê os parâmetros de GET /empresas.
+func parseListQuery(r *http.Request) (repository.ListQuery, error) {
+	v := r.URL.Query()
+	q := repository.ListQuery{
+		Cursor:              v.Get("cursor"),
+		NomeFantasiaPrefix:  v.Get("nome_fantasia"),
+		RazaoSocialContains: v.Get("razao_social"),
+	}
+	if sort := v.Get("sort"); sort != "" {
+		q.Sort = strings.TrimPrefix(sort, "-")
+		q.Desc = strings.HasPrefix(sort, "-")
+	}
+	var err error
+	if q.Limit, err = queryInt(v.Get("limit"), "limit"); err != nil {
+		return q, err
+	}
+	if q.Limit < 0 {
+		return q, errors.New("limit deve ser positivo")
+	}
+	for _, p := range []struct {
+		name string
+		dst  **int
+	}{
+		{"min_funcionarios", &q.MinFuncionarios},
+		{"max_funcionarios", &q.MaxFuncionarios},
+	} {
+		if raw := v.Get(p.name); raw != "" {
+			n, err := queryInt(raw, p.name)
+			if err != nil {
+				return q, err
+			}
+			*p.dst = &n
+		}
+	}
+	return q, nil
+}
+
+// queryInt converte um parâmetro de consulta numérico; vazio resulta em 0.
+func queryInt(raw, name string) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s deve ser um número inteiro", name)
+	}
+	return n, nil
+}
+
+// compliance trata GET /empresas/compliance: relatório das empresas abaixo
+// da cota legal de PCD, ordenadas do maior para o menor déficit.
 // Status:
-// - 200 com a lista de empresas.
+// - 200 com {"items": [...], "total": <quantidade>}.
 // - 500 em caso de falha no repositório.
-func (s *Server) list(w http.ResponseWriter, r *http.Request) {
-	items, err := s.repo.List(r.Context())
+func (s *Server) compliance(w http.ResponseWriter, r *http.Request) {
+	// empresas com menos de pcd.MinimoEmpregados são isentas; as demais são
+	// percorridas página a página.
+	min := pcd.MinimoEmpregados
+	q := repository.ListQuery{Limit: repository.MaxLimit, MinFuncionarios: &min}
+	pendentes := make([]models.Empresa, 0)
+	for {
+		res, err := s.repo.List(r.Context(), q)
+		if err != nil {
+			writeRepoError(w, err)
+			return
+		}
+		for _, e := range res.Items {
+			apresentar(&e)
+			if e.StatusPCD == string(pcd.StatusNaoConforme) {
+				pendentes = append(pendentes, e)
+			}
+		}
+		if res.NextCursor == "" {
+			break
+		}
+		q.Cursor = res.NextCursor
+	}
+	sort.SliceStable(pendentes, func(i, j int) bool {
+		if pendentes[i].DeficitPCD != pendentes[j].DeficitPCD {
+			return pendentes[i].DeficitPCD > pendentes[j].DeficitPCD
+		}
+		return pendentes[i].NomeFantasia < pendentes[j].NomeFantasia
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": pendentes, "total": len(pendentes)})
+}
+
+// search trata GET /empresas/search?q=<termos>[&limit=<n>]: busca textual
+// em nome fantasia, razão social e endereço, sem diferenciar acentos.
+// Status:
+// - 200 com {"items": [{..empresa, "score": <relevância>}], "total": <n>}, do mais relevante ao menos.
+// - 400 se q estiver vazio ou limit for inválido.
+// - 500 em caso de falha no repositório.
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	text := strings.TrimSpace(r.URL.Query().Get("q"))
+	if text == "" {
+		writeError(w, http.StatusBadRequest, "parâmetro q obrigatório")
+		return
+	}
+	limit, err := queryInt(r.URL.Query().Get("limit"), "limit")
 	if err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+		writeError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	writeJSON(w, http.StatusOK, items)
+	hits, err := s.repo.Search(r.Context(), text, limit)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	for i := range hits {
+		apresentar(&hits[i].Empresa)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": hits, "total": len(hits)})
 }
 
 // get trata GET /empresas/{id}.
 // Status:
-// - 200 com a empresa encontrada.
+// - 200 com a empresa encontrada, sua versão no header ETag e updated_at em
+// Last-Modified.
+// - 304 se If-None-Match (ou, na falta dele, If-Modified-Since) indicar que
+// a cópia do cliente ainda é atual.
+// - 400 se o ID for inválido.
 // - 404 se não existir.
 func (s *Server) get(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	item, err := s.repo.Get(r.Context(), id)
 	if err != nil {
-		writeError(w, http.StatusNotFound, "não encontrado")
+		writeRepoError(w, err)
 		return
 	}
+	cacheHeaders(w, etag(item.Version), item.UpdatedAt)
+	if notModified(w, r, etag(item.Version), item.UpdatedAt) {
+		return
+	}
+	apresentar(item)
 	writeJSON(w, http.StatusOK, item)
 }
 
 // update trata PUT /empresas/{id}.
 // Status:
-// - 200 em caso de sucesso.
-// - 400 para erros de validação/negócio (inclui unicidade de CNPJ).
-// publica "Edição da EMPRESA ..." se Publisher estiver configurado.
+// - 200 em caso de sucesso (com "warnings" se houver avisos e a nova ETag).
+// - 400 para corpo malformado.
+// - 404 se não existir (nenhum evento é publicado).
+// - 409 se o CNPJ pertencer a outra empresa.
+// - 412 se If-Match não corresponder à versão atual.
+// - 415 para Content-Type não suportado.
+// - 422 com a lista de campos inválidos, inclusive CNPJ.
+// - 428 se If-Match for obrigatório e não tiver sido enviado.
+// publica o evento EmpresaAtualizada se Publisher estiver configurado.
 func (s *Server) update(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	e, err := parseEmpresaFromRequest(r)
-	if err != nil {
-		writeError(w, http.StatusBadRequest, "form inválido")
+	e, avisos, ok := s.readEmpresa(w, r)
+	if !ok {
 		return
 	}
-
-	if err := validation.ValidateCNPJ(e.CNPJ); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+	version, ok := s.ifMatch(w, r, id, nil)
+	if !ok {
 		return
 	}
 
-	if err := s.repo.Update(r.Context(), id, &e); err != nil {
-		writeError(w, http.StatusBadRequest, err.Error())
+	if err := s.repo.Update(r.Context(), id, &e, version); err != nil {
+		writeRepoError(w, err)
 		return
 	}
-	if s.pub != nil {
-		_ = s.pub.Publish("Edição da EMPRESA " + e.NomeFantasia)
+	s.publish(r.Context(), id, e.Version)
+	resp := map[string]interface{}{"status": "ok"}
+	if len(avisos) > 0 {
+		resp["warnings"] = avisos
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	w.Header().Set("ETag", etag(e.Version))
+	writeJSON(w, http.StatusOK, resp)
 }
 
-// delete trata DELETE /empresas/{id}.
+// delete trata DELETE /empresas/{id}. A exclusão é lógica: a empresa deixa
+// de aparecer na API e libera o CNPJ, mas pode ser restaurada com POST
+// /empresas/{id}/restore até ser removida de vez pelo expurgo.
 // Status:
 // - 200 em caso de sucesso.
+// - 400 se o ID for inválido.
+// - 404 se não existir (nenhum evento é publicado).
+// - 412 se If-Match não corresponder à versão atual.
+// - 428 se If-Match for obrigatório e não tiver sido enviado.
 // - 500 em falha de exclusão.
+// publica o evento EmpresaExcluida se Publisher estiver configurado.
 func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	// Buscar o item antes para obter o nome na mensagem de evento.
-	item, _ := s.repo.Get(r.Context(), id)
-	if err := s.repo.Delete(r.Context(), id); err != nil {
-		writeError(w, http.StatusInternalServerError, err.Error())
+	item, err := s.repo.Get(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
 		return
 	}
-	name := ""
-	if item != nil {
-		name = item.NomeFantasia
+	version, ok := s.ifMatch(w, r, id, item)
+	if !ok {
+		return
 	}
-	if s.pub != nil {
-		_ = s.pub.Publish("Exclusão da EMPRESA " + name)
+	if err := s.repo.Delete(r.Context(), id, version); err != nil {
+		writeRepoError(w, err)
+		return
 	}
+	s.publishLast(r.Context(), id, repository.OpDelete)
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
+
+// restore trata POST /empresas/{id}/restore, desfazendo a exclusão lógica.
+// Status:
+// - 200 com a empresa restaurada e a nova ETag.
+// - 400 se o ID for inválido.
+// - 404 se não houver empresa excluída com esse ID.
+// - 409 se o CNPJ tiver sido cadastrado novamente por outra empresa.
+// publica o evento EmpresaRestaurada se Publisher estiver configurado.
+func (s *Server) restore(w http.ResponseWriter, r *http.Request) {
+	item, err := s.repo.Restore(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	s.publish(r.Context(), item.ID, item.Version)
+	apresentar(item)
+	w.Header().Set("ETag", etag(item.Version))
+	writeJSON(w, http.StatusOK, item)
+}
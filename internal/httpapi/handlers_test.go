@@ -3,52 +3,61 @@ package httpapi
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"matriz/internal/auth"
+	"matriz/internal/events"
+	"matriz/internal/messaging"
 	"matriz/internal/models"
+	"matriz/internal/pcd"
+	"matriz/internal/repository"
 )
 
-type fakeRepo struct{}
-
-func (f *fakeRepo) Create(ctx context.Context, e *models.Empresa) (string, error) { return "1", nil }
-func (f *fakeRepo) Get(ctx context.Context, id string) (*models.Empresa, error) {
-	return &models.Empresa{ID: id}, nil
-}
-func (f *fakeRepo) GetByCNPJ(ctx context.Context, cnpj string) (*models.Empresa, error) {
-	return nil, nil
-}
-func (f *fakeRepo) List(ctx context.Context) ([]models.Empresa, error) {
-	return []models.Empresa{}, nil
-}
-func (f *fakeRepo) Update(ctx context.Context, id string, e *models.Empresa) error { return nil }
-func (f *fakeRepo) Delete(ctx context.Context, id string) error                    { return nil }
-
-type nopPub struct{}
-
-func (n *nopPub) Publish(msg string) error { return nil }
+func newRepo() *repository.MemoryEmpresaRepo { return repository.NewMemoryEmpresaRepo() }
 
 func TestCreateValidation(t *testing.T) {
-	api := NewServer(&fakeRepo{}, nil)
-	form := url.Values{}
-	form.Set("nome_fantasia", "X")
-	req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(form.Encode()))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	rec := httptest.NewRecorder()
-	api.create(rec, req)
-	if rec.Code != http.StatusBadRequest {
-		t.Fatalf("expected 400, got %d", rec.Code)
+	api := NewServer(newRepo(), nil)
+	for _, cnpj := range []string{"", "04252011000111"} {
+		// o CNPJ inválido é devolvido junto com os demais erros de campo
+		form := url.Values{}
+		form.Set("cnpj", cnpj)
+		form.Set("nome_fantasia", "X")
+		form.Set("num_funcionarios", "-1")
+		req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		api.create(rec, req)
+		if rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("cnpj %q: expected 422, got %d", cnpj, rec.Code)
+		}
+		var body struct {
+			Fields []struct {
+				Field string `json:"field"`
+			} `json:"fields"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Fields) != 2 || body.Fields[0].Field != "cnpj" || body.Fields[1].Field != "num_funcionarios" {
+			t.Errorf("cnpj %q: fields = %v", cnpj, body.Fields)
+		}
 	}
 }
 
 func TestCreateFormURLEncoded(t *testing.T) {
-	api := NewServer(&fakeRepo{}, nil)
+	api := NewServer(newRepo(), nil)
 	form := url.Values{}
-	form.Set("cnpj", "12345678000199")
+	form.Set("cnpj", "04252011000110")
 	form.Set("nome_fantasia", "Loja X")
 	req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
@@ -60,25 +69,33 @@ func TestCreateFormURLEncoded(t *testing.T) {
 }
 
 func TestUpdateFormURLEncoded(t *testing.T) {
-	api := NewServer(&fakeRepo{}, nil)
+	repo := newRepo()
+	id, err := repo.Create(context.Background(), &models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewServer(repo, nil)
 	form := url.Values{}
-	form.Set("cnpj", "12345678000199")
+	form.Set("cnpj", "04252011000110")
 	form.Set("nome_fantasia", "Loja X")
-	req := httptest.NewRequest(http.MethodPut, "/empresas/1", strings.NewReader(form.Encode()))
+	req := httptest.NewRequest(http.MethodPut, "/empresas/"+id, strings.NewReader(form.Encode()))
 	req = req.WithContext(context.Background())
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	rec := httptest.NewRecorder()
-	api.update(rec, req)
+	api.Routes().ServeHTTP(rec, req)
 	if rec.Code != http.StatusOK {
 		t.Fatalf("expected 200, got %d", rec.Code)
 	}
+	if got, _ := repo.Get(context.Background(), id); got.NomeFantasia != "Loja X" {
+		t.Errorf("nome_fantasia = %q, want %q", got.NomeFantasia, "Loja X")
+	}
 }
 
 func TestCreateMultipart(t *testing.T) {
-	api := NewServer(&fakeRepo{}, nil)
+	api := NewServer(newRepo(), nil)
 	var buf bytes.Buffer
 	w := multipart.NewWriter(&buf)
-	_ = w.WriteField("cnpj", "12345678000199")
+	_ = w.WriteField("cnpj", "04252011000110")
 	_ = w.WriteField("nome_fantasia", "Loja Y")
 	w.Close()
 	req := httptest.NewRequest(http.MethodPost, "/empresas", bytes.NewReader(buf.Bytes()))
@@ -89,3 +106,974 @@ func TestCreateMultipart(t *testing.T) {
 		t.Fatalf("expected 201, got %d", rec.Code)
 	}
 }
+
+func TestCreateJSON(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	body := `{"cnpj":"04.252.011/0001-10","nome_fantasia":"Loja J","num_funcionarios":150,"num_min_pcd":3}`
+	req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	rec := httptest.NewRecorder()
+	api.create(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreateJSONInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "campo desconhecido", body: `{"cnpj":"04252011000110","id":"x"}`},
+		{name: "sintaxe", body: `{"cnpj":`},
+		{name: "corpo vazio", body: ``},
+		{name: "dois objetos", body: `{"cnpj":"04252011000110"}{}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewServer(newRepo(), nil)
+			req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			api.create(rec, req)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("expected 400, got %d: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUpdateUnsupportedMediaType(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	req := httptest.NewRequest(http.MethodPut, "/empresas/1", strings.NewReader("<empresa/>"))
+	req.Header.Set("Content-Type", "application/xml")
+	rec := httptest.NewRecorder()
+	api.update(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("expected 415, got %d", rec.Code)
+	}
+}
+
+func TestCreateFieldErrors(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	form := url.Values{}
+	form.Set("cnpj", "04252011000110")
+	form.Set("num_funcionarios", "dez")
+	form.Set("num_min_pcd", "-1")
+	form.Set("endereco", strings.Repeat("x", 301))
+	req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	api.create(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+	var body struct {
+		Fields []struct {
+			Field string `json:"field"`
+		} `json:"fields"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, f := range body.Fields {
+		got[f.Field] = true
+	}
+	for _, f := range []string{"num_funcionarios", "num_min_pcd", "endereco"} {
+		if !got[f] {
+			t.Errorf("expected error for %s, got %v", f, body.Fields)
+		}
+	}
+}
+
+func TestCreateJSONFieldType(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		api.create(rec, req)
+		return rec
+	}
+	rec := post(`{"cnpj":"04252011000110","num_funcionarios":"x","num_min_pcd":"y","num_pcd_contratados":"z"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var body struct {
+		Fields []struct {
+			Field string `json:"field"`
+		} `json:"fields"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, f := range body.Fields {
+		got[f.Field] = true
+	}
+	// todos os campos de tipo incorreto são reportados, não só o primeiro
+	for _, f := range []string{"num_funcionarios", "num_min_pcd", "num_pcd_contratados"} {
+		if !got[f] {
+			t.Errorf("expected error for %s, got %v", f, body.Fields)
+		}
+	}
+
+	// um campo desconhecido é rejeitado mesmo com erros de tipo
+	rec = post(`{"cnpj":"04252011000110","num_funcionarios":"x","bogus":1}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "bogus") {
+		t.Errorf("campo desconhecido: expected 400 citing bogus, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCreatePCDPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   pcd.Policy
+		wantCode int
+		wantWarn bool
+	}{
+		{name: "compute", policy: pcd.PolicyCompute, wantCode: http.StatusCreated},
+		{name: "validate", policy: pcd.PolicyValidate, wantCode: http.StatusUnprocessableEntity},
+		{name: "warn", policy: pcd.PolicyWarn, wantCode: http.StatusCreated, wantWarn: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewServer(newRepo(), nil, WithPCDPolicy(tt.policy))
+			body := `{"cnpj":"04252011000110","num_funcionarios":250,"num_min_pcd":1}`
+			req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			api.create(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Fatalf("expected %d, got %d: %s", tt.wantCode, rec.Code, rec.Body.String())
+			}
+			if got := strings.Contains(rec.Body.String(), `"warnings"`); got != tt.wantWarn {
+				t.Errorf("warnings = %v, want %v: %s", got, tt.wantWarn, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCompliance(t *testing.T) {
+	repo := newRepo()
+	for _, e := range []models.Empresa{
+		{CNPJ: "04252011000110", NomeFantasia: "Pequena", NumFuncionarios: 50},
+		{CNPJ: "11222333000181", NomeFantasia: "Conforme", NumFuncionarios: 100, NumPCDContratados: 2},
+		{CNPJ: "12ABC34501DE35", NomeFantasia: "Média", NumFuncionarios: 300, NumPCDContratados: 1},
+		{CNPJ: "12345678000195", NomeFantasia: "Grande", NumFuncionarios: 2000, NumPCDContratados: 10},
+	} {
+		if _, err := repo.Create(context.Background(), &e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	api := NewServer(repo, nil)
+	req := httptest.NewRequest(http.MethodGet, "/empresas/compliance", nil)
+	rec := httptest.NewRecorder()
+	api.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var body struct {
+		Items []models.Empresa `json:"items"`
+		Total int              `json:"total"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Total != 2 || len(body.Items) != 2 {
+		t.Fatalf("expected 2 non-compliant companies, got %+v", body)
+	}
+	if body.Items[0].NomeFantasia != "Grande" || body.Items[0].DeficitPCD != 90 ||
+		body.Items[1].NomeFantasia != "Média" || body.Items[1].DeficitPCD != 8 {
+		t.Errorf("unexpected order/deficit: %+v", body.Items)
+	}
+	// o déficit usa a cota legal, devolvida junto com o num_min_pcd gravado
+	if m := body.Items[1]; m.CotaLegalPCD != 9 || m.NumMinPCD != 0 {
+		t.Errorf("Média: cota_legal_pcd %d, num_min_pcd %d; want 9 e 0", m.CotaLegalPCD, m.NumMinPCD)
+	}
+}
+
+// queryRepo registra a ListQuery recebida e devolve uma página fixa.
+type queryRepo struct {
+	*repository.MemoryEmpresaRepo
+	got repository.ListQuery
+}
+
+func (q *queryRepo) List(ctx context.Context, lq repository.ListQuery) (*repository.ListResult, error) {
+	q.got = lq
+	return &repository.ListResult{Items: []models.Empresa{{ID: "1"}}, NextCursor: "abc", Total: 7}, nil
+}
+
+func TestListQuery(t *testing.T) {
+	repo := &queryRepo{MemoryEmpresaRepo: newRepo()}
+	api := NewServer(repo, nil)
+	req := httptest.NewRequest(http.MethodGet, "/empresas?limit=10&cursor=xyz&sort=-num_funcionarios&nome_fantasia=Lo&razao_social=ltda&min_funcionarios=5&max_funcionarios=50", nil)
+	rec := httptest.NewRecorder()
+	api.list(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	q := repo.got
+	if q.Limit != 10 || q.Cursor != "xyz" || q.Sort != "num_funcionarios" || !q.Desc ||
+		q.NomeFantasiaPrefix != "Lo" || q.RazaoSocialContains != "ltda" ||
+		q.MinFuncionarios == nil || *q.MinFuncionarios != 5 || q.MaxFuncionarios == nil || *q.MaxFuncionarios != 50 {
+		t.Errorf("unexpected query: %+v", q)
+	}
+	var body struct {
+		Items      []models.Empresa `json:"items"`
+		NextCursor string           `json:"next_cursor"`
+		Total      int64            `json:"total"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Items) != 1 || body.NextCursor != "abc" || body.Total != 7 {
+		t.Errorf("unexpected body: %+v", body)
+	}
+}
+
+func TestListInvalidQuery(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	for _, qs := range []string{"limit=abc", "limit=-1", "min_funcionarios=x"} {
+		req := httptest.NewRequest(http.MethodGet, "/empresas?"+qs, nil)
+		rec := httptest.NewRecorder()
+		api.list(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", qs, rec.Code)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	repo := newRepo()
+	e := models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Padaria Estrela"}
+	if _, err := repo.Create(context.Background(), &e); err != nil {
+		t.Fatal(err)
+	}
+	api := NewServer(repo, nil)
+	req := httptest.NewRequest(http.MethodGet, "/empresas/search?q=padaria", nil)
+	rec := httptest.NewRecorder()
+	api.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var body struct {
+		Items []struct {
+			ID            string  `json:"id"`
+			CNPJFormatado string  `json:"cnpj_formatado"`
+			Score         float64 `json:"score"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Items) != 1 || body.Items[0].Score <= 0 || body.Items[0].CNPJFormatado != "04.252.011/0001-10" {
+		t.Errorf("unexpected body: %+v", body)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/empresas/search?q=+", nil)
+	rec = httptest.NewRecorder()
+	api.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty q, got %d", rec.Code)
+	}
+}
+
+func TestCreateDuplicateCNPJ(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	for i, cnpj := range []string{"04252011000110", "04.252.011/0001-10"} {
+		form := url.Values{}
+		form.Set("cnpj", cnpj)
+		req := httptest.NewRequest(http.MethodPost, "/empresas", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		api.create(rec, req)
+		want := http.StatusCreated
+		if i > 0 {
+			want = http.StatusConflict
+		}
+		if rec.Code != want {
+			t.Fatalf("%s: expected %d, got %d", cnpj, want, rec.Code)
+		}
+	}
+}
+
+// failingRepo devolve err em todas as leituras.
+type failingRepo struct {
+	*repository.MemoryEmpresaRepo
+	err error
+}
+
+func (f *failingRepo) Get(ctx context.Context, id string) (*models.Empresa, error) { return nil, f.err }
+
+func TestGetErrorStatus(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"não encontrado", repository.ErrNotFound, http.StatusNotFound},
+		{"id inválido", repository.ErrInvalidID, http.StatusBadRequest},
+		{"indisponível", fmt.Errorf("%w: timeout", repository.ErrUnavailable), http.StatusServiceUnavailable},
+		{"outro", errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewServer(&failingRepo{MemoryEmpresaRepo: newRepo(), err: tt.err}, nil)
+			req := httptest.NewRequest(http.MethodGet, "/empresas/65f1c0ffee0000000000beef", nil)
+			rec := httptest.NewRecorder()
+			api.Routes().ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+// IDs que não são ObjectID são procurados como _id string, como nas versões
+// anteriores, e não existindo dão 404.
+func TestUpdateStringIDNotFound(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	form := url.Values{}
+	form.Set("cnpj", "04252011000110")
+	req := httptest.NewRequest(http.MethodPut, "/empresas/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	api.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestMissingIDNotFound(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	form := url.Values{}
+	form.Set("cnpj", "04252011000110")
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPut, "/empresas/65f1c0ffee0000000000beef", strings.NewReader(form.Encode())),
+		httptest.NewRequest(http.MethodDelete, "/empresas/65f1c0ffee0000000000beef", nil),
+	} {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		api.Routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404, got %d", req.Method, rec.Code)
+		}
+	}
+}
+
+func TestPatch(t *testing.T) {
+	repo := newRepo()
+	id, err := repo.Create(context.Background(), &models.Empresa{
+		CNPJ: "04252011000110", NomeFantasia: "Loja", RazaoSocial: "Loja LTDA",
+		Endereco: "Rua A", NumFuncionarios: 150, NumMinPCD: 3, NumPCDContratados: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewServer(repo, nil)
+	tests := []struct {
+		name   string
+		ct     string
+		body   string
+		status int
+		want   models.Empresa
+	}{
+		{
+			name:   "merge altera só os campos informados",
+			ct:     "application/merge-patch+json",
+			body:   `{"nome_fantasia":"Loja Nova","num_funcionarios":250}`,
+			status: http.StatusOK,
+			want: models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja Nova", RazaoSocial: "Loja LTDA",
+				Endereco: "Rua A", NumFuncionarios: 250, NumMinPCD: 8, NumPCDContratados: 3},
+		},
+		{
+			name:   "merge com null esvazia o campo",
+			ct:     "application/merge-patch+json",
+			body:   `{"endereco":null}`,
+			status: http.StatusOK,
+			want: models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja Nova", RazaoSocial: "Loja LTDA",
+				NumFuncionarios: 250, NumMinPCD: 8, NumPCDContratados: 3},
+		},
+		{
+			name:   "json patch com test",
+			ct:     "application/json-patch+json",
+			body:   `[{"op":"test","path":"/nome_fantasia","value":"Loja Nova"},{"op":"replace","path":"/num_pcd_contratados","value":5},{"op":"add","path":"/endereco","value":"Rua B"}]`,
+			status: http.StatusOK,
+			want: models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja Nova", RazaoSocial: "Loja LTDA",
+				Endereco: "Rua B", NumFuncionarios: 250, NumMinPCD: 8, NumPCDContratados: 5},
+		},
+		{
+			name:   "test falhou",
+			ct:     "application/json-patch+json",
+			body:   `[{"op":"test","path":"/nome_fantasia","value":"Outra"},{"op":"remove","path":"/endereco"}]`,
+			status: http.StatusConflict,
+		},
+		{
+			name:   "caminho inexistente",
+			ct:     "application/json-patch+json",
+			body:   `[{"op":"remove","path":"/telefone"}]`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "campo desconhecido no resultado",
+			ct:     "application/merge-patch+json",
+			body:   `{"telefone":"123"}`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "resultado inválido",
+			ct:     "application/merge-patch+json",
+			body:   `{"num_pcd_contratados":300}`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "tipo incorreto",
+			ct:     "application/merge-patch+json",
+			body:   `{"num_funcionarios":"muitos"}`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "remover cnpj",
+			ct:     "application/merge-patch+json",
+			body:   `{"cnpj":null}`,
+			status: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "patch malformado",
+			ct:     "application/json-patch+json",
+			body:   `{"op":"remove"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "content-type não suportado",
+			ct:     "application/json",
+			body:   `{"nome_fantasia":"X"}`,
+			status: http.StatusUnsupportedMediaType,
+		},
+	}
+	var last models.Empresa
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/empresas/"+id, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.ct)
+			rec := httptest.NewRecorder()
+			api.Routes().ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			got, err := repo.Get(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if tt.status != http.StatusOK {
+				want = last // falhas não alteram o documento
+			}
+			want.ID = id
+			// os campos mantidos pelo repositório são verificados em TestIfMatch,
+			// TestConditionalGet e na suíte storetest
+			want.Version, want.CreatedAt, want.UpdatedAt = got.Version, got.CreatedAt, got.UpdatedAt
+			if *got != want {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+			last = *got
+			last.ID = ""
+		})
+	}
+}
+
+func TestPatchNotFound(t *testing.T) {
+	api := NewServer(newRepo(), nil)
+	req := httptest.NewRequest(http.MethodPatch, "/empresas/65f1c0ffee0000000000beef", strings.NewReader(`{"nome_fantasia":"X"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rec := httptest.NewRecorder()
+	api.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	repo := newRepo()
+	id, err := repo.Create(context.Background(), &models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewServer(repo, nil).Routes()
+	form := url.Values{"cnpj": {"04252011000110"}, "nome_fantasia": {"Loja 2"}}
+	do := func(method, ct, body, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/empresas/"+id, strings.NewReader(body))
+		if ct != "" {
+			req.Header.Set("Content-Type", ct)
+		}
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	const formType = "application/x-www-form-urlencoded"
+
+	if rec := do(http.MethodGet, "", "", ""); rec.Header().Get("ETag") != `"1"` {
+		t.Fatalf("GET: ETag = %q, want \"1\"", rec.Header().Get("ETag"))
+	}
+	for _, tt := range []struct {
+		name, method, ct, body, ifMatch string
+		status                          int
+		etag                            string
+	}{
+		{"PUT na versão atual", http.MethodPut, formType, form.Encode(), `"1"`, http.StatusOK, `"2"`},
+		{"PUT em versão antiga", http.MethodPut, formType, form.Encode(), `"1"`, http.StatusPreconditionFailed, `"2"`},
+		{"PUT com ETag fraca", http.MethodPut, formType, form.Encode(), `W/"2"`, http.StatusPreconditionFailed, `"2"`},
+		{"PUT com lista", http.MethodPut, formType, form.Encode(), `"1", "2"`, http.StatusOK, `"3"`},
+		{"PATCH em versão antiga", http.MethodPatch, mergePatchType, `{"endereco":"Rua A"}`, `"2"`, http.StatusPreconditionFailed, `"3"`},
+		{"PATCH na versão atual", http.MethodPatch, mergePatchType, `{"endereco":"Rua A"}`, `"3"`, http.StatusOK, `"4"`},
+		{"PATCH sem alterações", http.MethodPatch, mergePatchType, `{"endereco":"Rua A"}`, `*`, http.StatusOK, `"4"`},
+		{"PATCH sem If-Match", http.MethodPatch, mergePatchType, `{"endereco":"Rua B"}`, "", http.StatusOK, `"5"`},
+		{"DELETE em versão antiga", http.MethodDelete, "", "", `"4"`, http.StatusPreconditionFailed, `"5"`},
+		{"DELETE na versão atual", http.MethodDelete, "", "", `"5"`, http.StatusOK, ""},
+		{"DELETE de empresa removida", http.MethodDelete, "", "", `"5"`, http.StatusNotFound, ""},
+	} {
+		rec := do(tt.method, tt.ct, tt.body, tt.ifMatch)
+		if rec.Code != tt.status || rec.Header().Get("ETag") != tt.etag {
+			t.Errorf("%s: got %d ETag %q, want %d ETag %q: %s",
+				tt.name, rec.Code, rec.Header().Get("ETag"), tt.status, tt.etag, rec.Body)
+		}
+	}
+}
+
+func TestRequireIfMatch(t *testing.T) {
+	repo := newRepo()
+	id, err := repo.Create(context.Background(), &models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewServer(repo, nil, WithRequireIfMatch(true)).Routes()
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/empresas/"+id, strings.NewReader(`{"cnpj":"04252011000110"}`))
+		req.Header.Set("Content-Type", "application/json")
+		if method == http.MethodPatch {
+			req.Header.Set("Content-Type", mergePatchType)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		if rec.Code != http.StatusPreconditionRequired {
+			t.Errorf("%s sem If-Match: expected 428, got %d", method, rec.Code)
+		}
+	}
+	if _, err := repo.Get(context.Background(), id); err != nil {
+		t.Errorf("empresa alterada sem If-Match: %v", err)
+	}
+}
+
+// racyRepo simula outra escrita entre a leitura e a gravação de um PATCH.
+type racyRepo struct {
+	*repository.MemoryEmpresaRepo
+	races int
+}
+
+func (r *racyRepo) Patch(ctx context.Context, id string, ch repository.Changes, version int64) (int64, error) {
+	if r.races > 0 {
+		r.races--
+		other := repository.Changes{Set: map[string]interface{}{"razao_social": "Outra LTDA"}}
+		if _, err := r.MemoryEmpresaRepo.Patch(ctx, id, other, repository.AnyVersion); err != nil {
+			return 0, err
+		}
+	}
+	return r.MemoryEmpresaRepo.Patch(ctx, id, ch, version)
+}
+
+func TestPatchConcurrentWrite(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		races   int
+		ifMatch string
+		status  int
+	}{
+		{"sem If-Match tenta de novo", 1, "", http.StatusOK},
+		{"com If-Match falha", 1, `"1"`, http.StatusPreconditionFailed},
+		{"desiste após maxPatchAttempts", maxPatchAttempts, "", http.StatusPreconditionFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &racyRepo{MemoryEmpresaRepo: newRepo(), races: tt.races}
+			id, err := repo.Create(context.Background(), &models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodPatch, "/empresas/"+id, strings.NewReader(`{"nome_fantasia":"Nova"}`))
+			req.Header.Set("Content-Type", mergePatchType)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			NewServer(repo, nil).Routes().ServeHTTP(rec, req)
+			if rec.Code != tt.status {
+				t.Fatalf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+			}
+			got, _ := repo.Get(context.Background(), id)
+			if got.RazaoSocial != "Outra LTDA" {
+				t.Errorf("escrita concorrente perdida: %+v", got)
+			}
+			if want := tt.status == http.StatusOK; (got.NomeFantasia == "Nova") != want {
+				t.Errorf("NomeFantasia = %q após status %d", got.NomeFantasia, rec.Code)
+			}
+		})
+	}
+}
+
+// changesRepo registra as alterações recebidas por Patch.
+type changesRepo struct {
+	*repository.MemoryEmpresaRepo
+	got repository.Changes
+}
+
+func (c *changesRepo) Patch(ctx context.Context, id string, ch repository.Changes, version int64) (int64, error) {
+	c.got = ch
+	return c.MemoryEmpresaRepo.Patch(ctx, id, ch, version)
+}
+
+func TestPatchRemovedFieldsSetZero(t *testing.T) {
+	repo := &changesRepo{MemoryEmpresaRepo: newRepo()}
+	id, err := repo.Create(context.Background(), &models.Empresa{
+		CNPJ: "04252011000110", NomeFantasia: "Loja", Endereco: "Rua A", NumFuncionarios: 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/empresas/"+id,
+		strings.NewReader(`{"nome_fantasia":null,"endereco":null,"num_funcionarios":null}`))
+	req.Header.Set("Content-Type", mergePatchType)
+	rec := httptest.NewRecorder()
+	NewServer(repo, nil).Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	// os campos removidos são gravados como zero, como num PUT sem eles,
+	// para que cursores e filtros de List continuem encontrando a empresa
+	want := map[string]interface{}{"nome_fantasia": "", "endereco": "", "num_funcionarios": 0}
+	if len(repo.got.Unset) > 0 || !reflect.DeepEqual(repo.got.Set, want) {
+		t.Errorf("alterações = %+v, want Set %v sem Unset", repo.got, want)
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	repo := newRepo()
+	id, err := repo.Create(context.Background(), &models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewServer(repo, nil).Routes()
+	get := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/empresas/" + id)
+	lastModified := rec.Header().Get("Last-Modified")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"1"` || lastModified == "" ||
+		rec.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("GET: %d, headers %v", rec.Code, rec.Header())
+	}
+	past := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	for _, tt := range []struct {
+		name   string
+		header []string
+		status int
+	}{
+		{"If-None-Match igual", []string{"If-None-Match", `"1"`}, http.StatusNotModified},
+		{"If-None-Match fraco", []string{"If-None-Match", `W/"1"`}, http.StatusNotModified},
+		{"If-None-Match em lista", []string{"If-None-Match", `"7", "1"`}, http.StatusNotModified},
+		{"If-None-Match diferente", []string{"If-None-Match", `"2"`}, http.StatusOK},
+		{"If-Modified-Since igual", []string{"If-Modified-Since", lastModified}, http.StatusNotModified},
+		{"If-Modified-Since antigo", []string{"If-Modified-Since", past}, http.StatusOK},
+		{"If-Modified-Since inválido", []string{"If-Modified-Since", "ontem"}, http.StatusOK},
+		{"If-None-Match tem precedência", []string{"If-None-Match", `"2"`, "If-Modified-Since", future}, http.StatusOK},
+	} {
+		rec := get("/empresas/"+id, tt.header...)
+		if rec.Code != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.status, rec.Code)
+		}
+		if rec.Code == http.StatusNotModified && (rec.Body.Len() != 0 || rec.Header().Get("ETag") != `"1"`) {
+			t.Errorf("%s: 304 com corpo %q ou sem ETag", tt.name, rec.Body)
+		}
+	}
+
+	e := models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Loja 2"}
+	if err := repo.Update(context.Background(), id, &e, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/empresas/"+id, "If-None-Match", `"1"`); rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"2"` {
+		t.Errorf("GET após alteração: %d ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	rec = get("/empresas?limit=1")
+	listTag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || listTag == "" {
+		t.Fatalf("GET /empresas: %d ETag %q", rec.Code, listTag)
+	}
+	if rec := get("/empresas?limit=1", "If-None-Match", listTag); rec.Code != http.StatusNotModified {
+		t.Errorf("GET /empresas inalterado: expected 304, got %d", rec.Code)
+	}
+	// uma nova empresa muda o total, mesmo fora da página
+	if _, err := repo.Create(context.Background(), &models.Empresa{CNPJ: "11222333000181"}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get("/empresas?limit=1", "If-None-Match", listTag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == listTag {
+		t.Errorf("GET /empresas após criação: %d ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestAuditFields(t *testing.T) {
+	repo := newRepo()
+	api := NewServer(repo, nil).Routes()
+	do := func(method, path, ct, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", ct)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), "ana"))
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	// os campos de auditoria são somente leitura
+	rec := do(http.MethodPost, "/empresas", "application/json", `{"cnpj":"04252011000110","created_by":"mallory"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("POST com created_by: expected 400, got %d", rec.Code)
+	}
+	form := url.Values{"cnpj": {"04252011000110"}, "created_by": {"mallory"}, "updated_at": {"2000-01-01T00:00:00Z"}}
+	rec = do(http.MethodPost, "/empresas", "application/x-www-form-urlencoded", form.Encode())
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("POST: expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	var created struct{ ID string }
+	_ = json.Unmarshal(rec.Body.Bytes(), &created)
+	rec = do(http.MethodPatch, "/empresas/"+created.ID, mergePatchType, `{"updated_by":"mallory"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("PATCH de updated_by: expected 422, got %d", rec.Code)
+	}
+
+	rec = do(http.MethodGet, "/empresas/"+created.ID, "", "")
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["created_by"] != "ana" || got["updated_by"] != "ana" || got["created_at"] == nil ||
+		got["updated_at"] != got["created_at"] || strings.HasPrefix(got["updated_at"].(string), "2000") {
+		t.Errorf("GET: auditoria = %v", got)
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	repo := newRepo()
+	api := NewServer(repo, nil).Routes()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(http.MethodPost, "/empresas", `{"cnpj":"04252011000110","nome_fantasia":"Padaria"}`)
+	var created struct{ ID string }
+	_ = json.Unmarshal(rec.Body.Bytes(), &created)
+
+	if rec := do(http.MethodPost, "/empresas/"+created.ID+"/restore", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("restore de empresa ativa: expected 404, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/empresas/"+created.ID, ""); rec.Code != http.StatusOK {
+		t.Fatalf("DELETE: expected 200, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/empresas/"+created.ID, ""); rec.Code != http.StatusNotFound {
+		t.Errorf("GET após DELETE: expected 404, got %d", rec.Code)
+	}
+	rec = do(http.MethodPost, "/empresas/"+created.ID+"/restore", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"3"` {
+		t.Fatalf("restore: expected 200 com ETag \"3\", got %d %q: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+	var got models.Empresa
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.ID != created.ID || got.DeletedAt != nil || got.CNPJFormatado == "" {
+		t.Errorf("restore: corpo = %+v, %v", got, err)
+	}
+	if rec := do(http.MethodGet, "/empresas/"+created.ID, ""); rec.Code != http.StatusOK {
+		t.Errorf("GET após restore: expected 200, got %d", rec.Code)
+	}
+
+	// o CNPJ liberado pela exclusão pode ser reutilizado; a restauração conflita
+	do(http.MethodDelete, "/empresas/"+created.ID, "")
+	if rec := do(http.MethodPost, "/empresas", `{"cnpj":"04252011000110"}`); rec.Code != http.StatusCreated {
+		t.Fatalf("POST com CNPJ de empresa excluída: expected 201, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/empresas/"+created.ID+"/restore", ""); rec.Code != http.StatusConflict {
+		t.Errorf("restore com CNPJ em uso: expected 409, got %d", rec.Code)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	repo := newRepo()
+	excluida, _ := repo.Create(context.Background(), &models.Empresa{CNPJ: "04252011000110"})
+	_ = repo.Delete(context.Background(), excluida, repository.AnyVersion)
+	purge := func(api http.Handler, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/admin/empresas/purge", nil)
+		if token != "" {
+			req.Header.Set("X-Admin-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := purge(NewServer(repo, nil).Routes(), "segredo"); rec.Code != http.StatusForbidden {
+		t.Errorf("sem token configurado: expected 403, got %d", rec.Code)
+	}
+	api := NewServer(repo, nil, WithAdminToken("segredo")).Routes()
+	for _, token := range []string{"", "errado"} {
+		if rec := purge(api, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: expected 401, got %d", token, rec.Code)
+		}
+	}
+	// dentro do período de retenção padrão nada é removido
+	if rec := purge(api, "segredo"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"purged":0}` {
+		t.Errorf("retenção padrão: got %d %s", rec.Code, rec.Body)
+	}
+	pub := &messaging.RecordingPublisher{}
+	api = NewServer(repo, pub, WithAdminToken("segredo"), WithPurgeRetention(-time.Minute)).Routes()
+	if rec := purge(api, "segredo"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"purged":1}` {
+		t.Errorf("retenção expirada: got %d %s", rec.Code, rec.Body)
+	}
+	if evs := pub.Events(); len(evs) != 1 || evs[0].Type != events.EmpresaExpurgada ||
+		evs[0].AggregateID != excluida || evs[0].AggregateVersion != 3 || evs[0].Payload != nil {
+		t.Errorf("eventos do expurgo = %+v, want um EmpresaExpurgada sem payload", evs)
+	}
+	if _, err := repo.Restore(context.Background(), excluida); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Restore após expurgo = %v, want ErrNotFound", err)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	api := NewServer(newRepo(), nil).Routes()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(http.MethodPost, "/empresas", `{"cnpj":"04252011000110","razao_social":"Antiga LTDA"}`)
+	var created struct{ ID string }
+	_ = json.Unmarshal(rec.Body.Bytes(), &created)
+	if rec := do(http.MethodPut, "/empresas/"+created.ID, `{"cnpj":"04252011000110","razao_social":"Nova LTDA"}`); rec.Code != http.StatusOK {
+		t.Fatalf("PUT: expected 200, got %d", rec.Code)
+	}
+	do(http.MethodDelete, "/empresas/"+created.ID, "")
+
+	// o histórico continua disponível após a exclusão
+	rec = do(http.MethodGet, "/empresas/"+created.ID+"/history", "")
+	var list struct {
+		Items []struct {
+			Version  int64
+			Op       string
+			Changes  []repository.FieldChange
+			Snapshot interface{}
+		}
+		Total int
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("history: %d %s", rec.Code, rec.Body)
+	}
+	if list.Total != 3 || list.Items[1].Op != "update" || list.Items[2].Op != "delete" || list.Items[0].Snapshot != nil {
+		t.Fatalf("history = %+v", list)
+	}
+	if c := list.Items[1].Changes; len(c) != 1 || c[0].Field != "razao_social" || c[0].Before != "Antiga LTDA" || c[0].After != "Nova LTDA" {
+		t.Errorf("history: alterações do PUT = %+v", c)
+	}
+
+	rec = do(http.MethodGet, "/empresas/"+created.ID+"/history/1", "")
+	var entry struct {
+		Version  int64
+		Snapshot models.Empresa
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("history/1: %d %s", rec.Code, rec.Body)
+	}
+	if entry.Version != 1 || entry.Snapshot.RazaoSocial != "Antiga LTDA" || entry.Snapshot.CNPJFormatado != "04.252.011/0001-10" {
+		t.Errorf("history/1 = %+v", entry)
+	}
+
+	for path, want := range map[string]int{
+		"/empresas/" + created.ID + "/history/0":     http.StatusBadRequest,
+		"/empresas/" + created.ID + "/history/abc":   http.StatusBadRequest,
+		"/empresas/" + created.ID + "/history/9":     http.StatusNotFound,
+		"/empresas/abc/history":                      http.StatusNotFound,
+		"/empresas/000000000000000000000000/history": http.StatusNotFound,
+	} {
+		if rec := do(http.MethodGet, path, ""); rec.Code != want {
+			t.Errorf("GET %s: expected %d, got %d", path, want, rec.Code)
+		}
+	}
+}
+
+func TestPublishEvents(t *testing.T) {
+	pub := &messaging.RecordingPublisher{}
+	api := NewServer(newRepo(), pub).Routes()
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if method == http.MethodPatch {
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+		}
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, req)
+		return rec
+	}
+	rec := do(http.MethodPost, "/empresas", `{"cnpj":"04252011000110","nome_fantasia":"Padaria"}`)
+	var created struct{ ID string }
+	_ = json.Unmarshal(rec.Body.Bytes(), &created)
+	path := "/empresas/" + created.ID
+	do(http.MethodPut, path, `{"cnpj":"04252011000110","nome_fantasia":"Padaria Nova"}`)
+	// PATCH sem alterações não publica
+	do(http.MethodPatch, path, `{"nome_fantasia":"Padaria Nova"}`)
+	do(http.MethodPatch, path, `{"endereco":"Rua A"}`)
+	do(http.MethodDelete, path, "")
+	do(http.MethodPost, path+"/restore", "")
+	// falhas não publicam
+	do(http.MethodDelete, "/empresas/000000000000000000000000", "")
+
+	want := []struct {
+		typ     string
+		version int64
+		msg     string
+	}{
+		{events.EmpresaCriada, 1, "Cadastro de EMPRESA Padaria"},
+		{events.EmpresaAtualizada, 2, "Edição da EMPRESA Padaria Nova"},
+		{events.EmpresaAtualizada, 3, "Edição da EMPRESA Padaria Nova"},
+		{events.EmpresaExcluida, 4, "Exclusão da EMPRESA Padaria Nova"},
+		{events.EmpresaRestaurada, 5, "Restauração da EMPRESA Padaria Nova"},
+	}
+	got := pub.Events()
+	if len(got) != len(want) {
+		t.Fatalf("eventos publicados = %d, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		ev := got[i]
+		if ev.Type != w.typ || ev.AggregateID != created.ID || ev.AggregateVersion != w.version || ev.Message != w.msg || ev.CNPJ != "04252011000110" {
+			t.Errorf("evento %d = %+v, want %s versão %d", i, ev, w.typ, w.version)
+		}
+	}
+	if f := got[2].ChangedFields; len(f) != 1 || f[0] != "endereco" {
+		t.Errorf("campos alterados do PATCH = %v", f)
+	}
+
+	// uma falha de publicação não afeta a resposta
+	pub.Fail(errors.New("broker fora do ar"))
+	if rec := do(http.MethodPut, path, `{"cnpj":"04252011000110","nome_fantasia":"Padaria"}`); rec.Code != http.StatusOK {
+		t.Errorf("PUT com publisher falhando: expected 200, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,54 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// history trata GET /empresas/{id}/history, listando as alterações da
+// empresa em ordem de versão (sem os snapshots). Empresas excluídas ou já
+// expurgadas continuam com o histórico disponível.
+// Status:
+// - 200 com {"items": [...], "total": n}.
+// - 404 se não houver histórico nem empresa com esse ID.
+func (s *Server) history(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	entries, err := s.repo.History(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	if len(entries) == 0 {
+		// empresas gravadas antes do histórico não têm entradas
+		if _, err := s.repo.Get(r.Context(), id); err != nil {
+			writeRepoError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"items": entries, "total": len(entries)})
+}
+
+// historyVersion trata GET /empresas/{id}/history/{version}, devolvendo a
+// entrada do histórico que gerou a versão, com o snapshot da empresa nela.
+// Status:
+// - 200 com a entrada e o snapshot em "snapshot" (null após o expurgo).
+// - 400 se a versão for inválida.
+// - 404 se a versão não estiver no histórico.
+func (s *Server) historyVersion(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.ParseInt(chi.URLParam(r, "version"), 10, 64)
+	if err != nil || version < 1 {
+		writeError(w, http.StatusBadRequest, "versão inválida")
+		return
+	}
+	entry, err := s.repo.HistoryVersion(r.Context(), chi.URLParam(r, "id"), version)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	if entry.Snapshot != nil {
+		apresentar(entry.Snapshot)
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
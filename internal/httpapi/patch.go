@@ -0,0 +1,195 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"matriz/internal/jsonpatch"
+	"matriz/internal/models"
+	"matriz/internal/repository"
+	"matriz/internal/validation"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Content-Types aceitos em PATCH /empresas/{id}.
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// patch trata PATCH /empresas/{id} com JSON Merge Patch (RFC 7396) ou
+// JSON Patch (RFC 6902), aplicados sobre os campos de empresaInput. O
+// documento resultante passa pelas mesmas validações de PUT e só os campos
+// alterados são gravados; campos removidos pelo patch são gravados vazios.
+// Status:
+// - 200 em caso de sucesso (com "warnings" se houver avisos e a nova ETag).
+// - 400 para patch malformado.
+// - 404 se não existir (nenhum evento é publicado).
+// - 409 se uma operação "test" falhar ou o CNPJ pertencer a outra empresa.
+// - 412 se If-Match não corresponder à versão atual.
+// - 415 para Content-Type diferente de application/merge-patch+json ou
+// application/json-patch+json.
+// - 422 se o patch não puder ser aplicado ou o resultado for inválido.
+// - 428 se If-Match for obrigatório e não tiver sido enviado.
+// publica o evento EmpresaAtualizada se algum campo mudou e o Publisher estiver configurado.
+func (s *Server) patch(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	mediatype, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	var apply func(doc interface{}, patch []byte) (interface{}, error)
+	switch strings.ToLower(mediatype) {
+	case mergePatchType:
+		apply = jsonpatch.Merge
+	case jsonPatchType:
+		apply = jsonpatch.Apply
+	default:
+		w.Header().Set("Accept-Patch", mergePatchType+", "+jsonPatchType)
+		writeError(w, http.StatusUnsupportedMediaType, "content-type não suportado")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, jsonError(err).Error())
+		return
+	}
+
+	for attempt := 1; ; attempt++ {
+		if !s.applyPatch(w, r, id, apply, body, attempt < maxPatchAttempts) {
+			return
+		}
+	}
+}
+
+// maxPatchAttempts limita as tentativas de um PATCH sem If-Match que
+// encontrou a empresa alterada entre a leitura e a escrita.
+const maxPatchAttempts = 3
+
+// applyPatch lê a versão atual da empresa, aplica o patch e grava as
+// alterações condicionadas a essa versão, escrevendo a resposta. Devolve
+// true, sem responder, quando a empresa mudou no meio do caminho, o cliente
+// não enviou If-Match e retry permite uma nova tentativa.
+func (s *Server) applyPatch(w http.ResponseWriter, r *http.Request, id string,
+	apply func(doc interface{}, patch []byte) (interface{}, error), body []byte, retry bool) bool {
+	old, err := s.repo.Get(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return false
+	}
+	expected, ok := s.ifMatch(w, r, id, old)
+	if !ok {
+		return false
+	}
+	doc, err := patchDoc(old)
+	if err != nil {
+		writeRepoError(w, err)
+		return false
+	}
+	patched, err := apply(doc, body)
+	if err != nil {
+		writePatchError(w, err)
+		return false
+	}
+	raw, err := json.Marshal(patched)
+	if err != nil {
+		writeRepoError(w, err)
+		return false
+	}
+	e, err := decodeEmpresa(bytes.NewReader(raw))
+	var campos validation.Errors
+	if err != nil && !errors.As(err, &campos) {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return false
+	}
+	avisos, ok := s.checkEmpresa(w, &e, campos)
+	if !ok {
+		return false
+	}
+
+	ch := empresaChanges(old, &e)
+	version := old.Version
+	if !ch.Empty() {
+		// o patch foi calculado sobre old, então a escrita é sempre
+		// condicionada à versão lida, com ou sem If-Match
+		version, err = s.repo.Patch(r.Context(), id, ch, old.Version)
+		if errors.Is(err, repository.ErrVersionConflict) && expected == repository.AnyVersion && retry {
+			return true
+		}
+		if err != nil {
+			writeRepoError(w, err)
+			return false
+		}
+		s.publish(r.Context(), id, version)
+	}
+	resp := map[string]interface{}{"status": "ok"}
+	if len(avisos) > 0 {
+		resp["warnings"] = avisos
+	}
+	w.Header().Set("ETag", etag(version))
+	writeJSON(w, http.StatusOK, resp)
+	return false
+}
+
+// patchDoc devolve os campos editáveis de e como documento JSON genérico,
+// o alvo sobre o qual o patch é aplicado.
+func patchDoc(e *models.Empresa) (interface{}, error) {
+	raw, err := json.Marshal(empresaInput{
+		CNPJ:              e.CNPJ,
+		NomeFantasia:      e.NomeFantasia,
+		RazaoSocial:       e.RazaoSocial,
+		Endereco:          e.Endereco,
+		NumFuncionarios:   e.NumFuncionarios,
+		NumMinPCD:         e.NumMinPCD,
+		NumPCDContratados: e.NumPCDContratados,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	err = json.Unmarshal(raw, &doc)
+	return doc, err
+}
+
+// empresaChanges compara a empresa validada e com a versão gravada old e
+// monta as alterações por campo. Um campo removido pelo patch é gravado com
+// o valor zero, como faria um PUT sem ele, e nunca removido com $unset: a
+// paginação por cursor e os filtros de List esperam todos os campos
+// presentes.
+func empresaChanges(old, e *models.Empresa) repository.Changes {
+	ch := repository.Changes{Set: map[string]interface{}{}}
+	for _, f := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"cnpj", old.CNPJ, e.CNPJ},
+		{"nome_fantasia", old.NomeFantasia, e.NomeFantasia},
+		{"razao_social", old.RazaoSocial, e.RazaoSocial},
+		{"endereco", old.Endereco, e.Endereco},
+		{"num_funcionarios", old.NumFuncionarios, e.NumFuncionarios},
+		{"num_min_pcd", old.NumMinPCD, e.NumMinPCD},
+		{"num_pcd_contratados", old.NumPCDContratados, e.NumPCDContratados},
+	} {
+		if f.old != f.new {
+			ch.Set[f.name] = f.new
+		}
+	}
+	return ch
+}
+
+// writePatchError responde a falhas ao aplicar o patch: 400 para patch
+// malformado, 409 para operação "test" que falhou e 422 para operações que
+// não se aplicam ao documento.
+func writePatchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		writeError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, jsonpatch.ErrNotApplicable):
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, err.Error())
+	}
+}
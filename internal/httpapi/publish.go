@@ -0,0 +1,45 @@
+package httpapi
+
+import (
+	"context"
+	"log"
+
+	"matriz/internal/events"
+)
+
+// publish publica, se houver Publisher, o evento da alteração que gerou a
+// versão version da empresa id, montado a partir do histórico. É o caminho
+// sem outbox: uma falha é registrada no log e o evento se perde.
+func (s *Server) publish(ctx context.Context, id string, version int64) {
+	if s.pub == nil {
+		return
+	}
+	entry, err := s.repo.HistoryVersion(ctx, id, version)
+	if err == nil {
+		err = s.pub.Publish(events.FromHistory(*entry))
+	}
+	if err != nil {
+		log.Printf("publicação do evento da versão %d da empresa %s: %v", version, id, err)
+	}
+}
+
+// publishLast publica o evento da última operação op (exclusão ou expurgo)
+// registrada para id. A versão gerada é procurada no histórico porque
+// Delete sem If-Match e Purge não a devolvem; a empresa excluída não recebe
+// novas alterações, então a última entrada op é a desta requisição.
+func (s *Server) publishLast(ctx context.Context, id, op string) {
+	if s.pub == nil {
+		return
+	}
+	history, err := s.repo.History(ctx, id)
+	if err != nil {
+		log.Printf("publicação do evento %s da empresa %s: %v", op, id, err)
+		return
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Op == op {
+			s.publish(ctx, id, history[i].Version)
+			return
+		}
+	}
+}
@@ -0,0 +1,313 @@
+// Package jsonpatch aplica JSON Merge Patch (RFC 7396) e JSON Patch
+// (RFC 6902) a documentos JSON decodificados com encoding/json
+// (map[string]interface{}, []interface{}, string, float64, bool e nil).
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Erros de Apply/Merge. ErrInvalidPatch indica um patch malformado;
+// ErrNotApplicable, uma operação que não pôde ser aplicada ao documento;
+// ErrTestFailed, uma operação "test" cujo valor não confere.
+var (
+	ErrInvalidPatch  = errors.New("patch inválido")
+	ErrNotApplicable = errors.New("patch não aplicável")
+	ErrTestFailed    = errors.New("operação test falhou")
+)
+
+// Merge aplica um JSON Merge Patch (RFC 7396) a doc e devolve o resultado.
+// doc não é modificado.
+func Merge(doc interface{}, patch []byte) (interface{}, error) {
+	var p interface{}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return mergeValue(clone(doc), p), nil
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{})
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergeValue(t[k], v)
+	}
+	return t
+}
+
+// Operation é uma operação de JSON Patch.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// Apply aplica um JSON Patch (RFC 6902) a doc e devolve o resultado. As
+// operações são aplicadas em ordem e, se qualquer uma falhar, nenhuma tem
+// efeito. doc não é modificado.
+func Apply(doc interface{}, patch []byte) (interface{}, error) {
+	var ops []Operation
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, fmt.Errorf("%w: esperado um array de operações: %v", ErrInvalidPatch, err)
+	}
+	doc = clone(doc)
+	for i, op := range ops {
+		var err error
+		doc, err = applyOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operação %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyOp(doc interface{}, op Operation) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+	value := func() (interface{}, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: value obrigatório", ErrInvalidPatch)
+		}
+		var v interface{}
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+		}
+		return v, nil
+	}
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "remove":
+		doc, _, err := remove(doc, path)
+		return doc, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if doc, _, err = remove(doc, path); err != nil {
+			return nil, err
+		}
+		return add(doc, path, v)
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" && isPrefix(from, path) && len(from) < len(path) {
+			return nil, fmt.Errorf("%w: não é possível mover um valor para dentro dele mesmo", ErrNotApplicable)
+		}
+		v, err := get(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if op.Op == "move" {
+			if doc, _, err = remove(doc, from); err != nil {
+				return nil, err
+			}
+		} else {
+			v = clone(v)
+		}
+		return add(doc, path, v)
+	case "test":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		cur, err := get(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(cur, v) {
+			return nil, ErrTestFailed
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("%w: operação %q desconhecida", ErrInvalidPatch, op.Op)
+	}
+}
+
+// parsePointer divide um JSON Pointer (RFC 6901) em tokens.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("%w: caminho %q deve começar com /", ErrInvalidPatch, p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func isPrefix(prefix, path []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func get(doc interface{}, path []string) (interface{}, error) {
+	cur := doc
+	for _, tok := range path {
+		switch c := cur.(type) {
+		case map[string]interface{}:
+			v, ok := c[tok]
+			if !ok {
+				return nil, fmt.Errorf("%w: campo %q não existe", ErrNotApplicable, tok)
+			}
+			cur = v
+		case []interface{}:
+			i, err := arrayIndex(tok, len(c)-1)
+			if err != nil {
+				return nil, err
+			}
+			cur = c[i]
+		default:
+			return nil, fmt.Errorf("%w: %q não é objeto nem array", ErrNotApplicable, tok)
+		}
+	}
+	return cur, nil
+}
+
+// add insere v em path, devolvendo o documento resultante (que é o próprio
+// v quando path aponta para a raiz).
+func add(doc interface{}, path []string, v interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return v, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = v
+		return doc, nil
+	case []interface{}:
+		i := len(p)
+		if last != "-" {
+			if i, err = arrayIndex(last, len(p)); err != nil {
+				return nil, err
+			}
+		}
+		p = append(p, nil)
+		copy(p[i+1:], p[i:])
+		p[i] = v
+		return replaceParent(doc, path[:len(path)-1], p)
+	default:
+		return nil, fmt.Errorf("%w: destino não é objeto nem array", ErrNotApplicable)
+	}
+}
+
+// remove apaga o valor em path e o devolve junto com o documento resultante.
+func remove(doc interface{}, path []string) (interface{}, interface{}, error) {
+	if len(path) == 0 {
+		return nil, doc, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		v, ok := p[last]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: campo %q não existe", ErrNotApplicable, last)
+		}
+		delete(p, last)
+		return doc, v, nil
+	case []interface{}:
+		i, err := arrayIndex(last, len(p)-1)
+		if err != nil {
+			return nil, nil, err
+		}
+		v := p[i]
+		p = append(p[:i:i], p[i+1:]...)
+		doc, err = replaceParent(doc, path[:len(path)-1], p)
+		return doc, v, err
+	default:
+		return nil, nil, fmt.Errorf("%w: destino não é objeto nem array", ErrNotApplicable)
+	}
+}
+
+// replaceParent grava o array modificado arr de volta em path, já que
+// append pode ter alocado um novo slice.
+func replaceParent(doc interface{}, path []string, arr []interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return arr, nil
+	}
+	parent, err := get(doc, path[:len(path)-1])
+	if err != nil {
+		return nil, err
+	}
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[last] = arr
+	case []interface{}:
+		i, _ := strconv.Atoi(last)
+		p[i] = arr
+	}
+	return doc, nil
+}
+
+// arrayIndex converte um token em índice de array entre 0 e max.
+func arrayIndex(tok string, max int) (int, error) {
+	i, err := strconv.Atoi(tok)
+	if err != nil || i < 0 || i > max || (len(tok) > 1 && tok[0] == '0') {
+		return 0, fmt.Errorf("%w: índice %q inválido", ErrNotApplicable, tok)
+	}
+	return i, nil
+}
+
+// clone copia profundamente um documento JSON decodificado.
+func clone(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, x := range t {
+			m[k] = clone(x)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(t))
+		for i, x := range t {
+			a[i] = clone(x)
+		}
+		return a
+	default:
+		return v
+	}
+}
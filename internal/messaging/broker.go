@@ -0,0 +1,88 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"matriz/internal/events"
+)
+
+// ErrSlowSubscriber indica que um inscrito do ChannelBroker estava com o
+// buffer cheio e não recebeu o evento.
+var ErrSlowSubscriber = errors.New("messaging: inscrito sem espaço no buffer")
+
+// ChannelBroker distribui os eventos em processo, por canais Go, a cada
+// inscrito. Serve para rodar a API e os consumidores no mesmo processo, sem
+// RabbitMQ. Publish nunca bloqueia: um inscrito com o buffer cheio perde o
+// evento.
+type ChannelBroker struct {
+	mu     sync.Mutex
+	subs   map[chan events.Event]struct{}
+	closed bool
+}
+
+// NewChannelBroker cria um ChannelBroker sem inscritos.
+func NewChannelBroker() *ChannelBroker {
+	return &ChannelBroker{subs: make(map[chan events.Event]struct{})}
+}
+
+// Subscribe inscreve um consumidor e devolve o canal, com buffer buffer,
+// em que ele recebe os eventos publicados a partir de agora, e a função que
+// cancela a inscrição e fecha o canal. Com o broker fechado, o canal já vem
+// fechado.
+func (b *ChannelBroker) Subscribe(buffer int) (<-chan events.Event, func()) {
+	ch := make(chan events.Event, buffer)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subs[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// Publish entrega ev a cada inscrito. Devolve ErrSlowSubscriber se algum
+// deles não tinha espaço para o evento; os demais o recebem mesmo assim.
+func (b *ChannelBroker) Publish(ev events.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return ErrClosed
+	}
+	missed := 0
+	for ch := range b.subs {
+		select {
+		case ch <- ev:
+		default:
+			missed++
+		}
+	}
+	if missed > 0 {
+		return fmt.Errorf("%w: evento %s perdido por %d inscrito(s)", ErrSlowSubscriber, ev.ID, missed)
+	}
+	return nil
+}
+
+// Close fecha os canais de todos os inscritos; publicações seguintes
+// falham com ErrClosed.
+func (b *ChannelBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for ch := range b.subs {
+		delete(b.subs, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,134 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"matriz/internal/events"
+)
+
+// Mode define como os eventos são representados como CloudEvents 1.0 nas
+// mensagens AMQP.
+type Mode string
+
+const (
+	// ModeBinary leva os atributos do CloudEvent nas propriedades de
+	// aplicação cloudEvents:* e o evento, em JSON, no corpo.
+	ModeBinary Mode = "binary"
+	// ModeStructured leva atributos e evento em um envelope JSON com
+	// content-type StructuredContentType.
+	ModeStructured Mode = "structured"
+)
+
+// ParseMode converte o valor de configuração em Mode.
+// String vazia resulta em ModeBinary.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case "":
+		return ModeBinary, nil
+	case ModeBinary, ModeStructured:
+		return m, nil
+	default:
+		return "", fmt.Errorf("modo CloudEvents inválido: %q (use binary ou structured)", s)
+	}
+}
+
+const (
+	// SpecVersion é a versão da especificação CloudEvents usada.
+	SpecVersion = "1.0"
+	// StructuredContentType é o content-type das mensagens em ModeStructured.
+	StructuredContentType = "application/cloudevents+json"
+	// HeaderPrefix prefixa os atributos nas propriedades de aplicação em
+	// ModeBinary.
+	HeaderPrefix = "cloudEvents:"
+	// DefaultSource é o atributo source padrão.
+	DefaultSource = "/matriz/empresas"
+	// TypePrefix prefixa o tipo do evento (events.EmpresaCriada...) no
+	// atributo type.
+	TypePrefix = "matriz.empresas."
+)
+
+// CloudEvent é o envelope de um evento em ModeStructured. Subject é o ID da
+// empresa e Data o events.Event em JSON.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// newCloudEvent monta o CloudEvent de ev, publicado por source.
+func newCloudEvent(ev events.Event, source string) (CloudEvent, error) {
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return CloudEvent{}, err
+	}
+	return CloudEvent{
+		SpecVersion:     SpecVersion,
+		ID:              ev.ID,
+		Source:          source,
+		Type:            TypePrefix + ev.Type,
+		Subject:         ev.AggregateID,
+		Time:            ev.OccurredAt,
+		DataContentType: events.ContentType,
+		Data:            data,
+	}, nil
+}
+
+// newPublishing monta a mensagem AMQP de ev no modo mode. Nos dois modos o
+// ID, o tipo e o instante do evento também vão nas propriedades da mensagem.
+func newPublishing(ev events.Event, mode Mode, source string) (amqp.Publishing, error) {
+	ce, err := newCloudEvent(ev, source)
+	if err != nil {
+		return amqp.Publishing{}, err
+	}
+	msg := amqp.Publishing{
+		MessageId:    ev.ID,
+		Type:         ev.Type,
+		Timestamp:    ev.OccurredAt,
+		DeliveryMode: amqp.Persistent,
+	}
+	if mode == ModeStructured {
+		msg.ContentType = StructuredContentType
+		msg.Body, err = json.Marshal(ce)
+		return msg, err
+	}
+	msg.ContentType = ce.DataContentType
+	msg.Body = ce.Data
+	msg.Headers = amqp.Table{
+		HeaderPrefix + "specversion": ce.SpecVersion,
+		HeaderPrefix + "id":          ce.ID,
+		HeaderPrefix + "source":      ce.Source,
+		HeaderPrefix + "type":        ce.Type,
+		HeaderPrefix + "subject":     ce.Subject,
+		HeaderPrefix + "time":        ce.Time.UTC().Format(time.RFC3339Nano),
+	}
+	return msg, nil
+}
+
+// DecodeEvent lê o events.Event de uma mensagem publicada por Publisher, em
+// qualquer dos modos.
+func DecodeEvent(d amqp.Delivery) (events.Event, error) {
+	data := d.Body
+	switch d.ContentType {
+	case StructuredContentType:
+		var ce CloudEvent
+		if err := json.Unmarshal(d.Body, &ce); err != nil {
+			return events.Event{}, err
+		}
+		data = ce.Data
+	case events.ContentType:
+	default:
+		return events.Event{}, fmt.Errorf("content-type não suportado: %q", d.ContentType)
+	}
+	var ev events.Event
+	err := json.Unmarshal(data, &ev)
+	return ev, err
+}
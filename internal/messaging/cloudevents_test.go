@@ -0,0 +1,104 @@
+package messaging
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"matriz/internal/events"
+	"matriz/internal/models"
+)
+
+func testEvent() events.Event {
+	return events.Event{
+		ID:               "665f1c2b8a1e4d0012345678-1",
+		Type:             events.EmpresaCriada,
+		SchemaVersion:    events.SchemaVersion,
+		OccurredAt:       time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		AggregateID:      "665f1c2b8a1e4d0012345678",
+		AggregateVersion: 1,
+		CNPJ:             "04252011000110",
+		ChangedFields:    []string{"cnpj", "nome_fantasia"},
+		Payload:          &models.Empresa{ID: "665f1c2b8a1e4d0012345678", CNPJ: "04252011000110", NomeFantasia: "Padaria"},
+		Message:          "Cadastro de EMPRESA Padaria",
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for in, want := range map[string]Mode{"": ModeBinary, "binary": ModeBinary, "structured": ModeStructured} {
+		if got, err := ParseMode(in); got != want || err != nil {
+			t.Errorf("ParseMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseMode("json"); err == nil {
+		t.Error("ParseMode aceitou modo inválido")
+	}
+}
+
+func TestPublishingBinary(t *testing.T) {
+	ev := testEvent()
+	msg, err := newPublishing(ev, ModeBinary, DefaultSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentType != events.ContentType || msg.MessageId != ev.ID || msg.Type != ev.Type || !msg.Timestamp.Equal(ev.OccurredAt) {
+		t.Errorf("propriedades = %q %q %q %v", msg.ContentType, msg.MessageId, msg.Type, msg.Timestamp)
+	}
+	want := amqp.Table{
+		"cloudEvents:specversion": "1.0",
+		"cloudEvents:id":          ev.ID,
+		"cloudEvents:source":      DefaultSource,
+		"cloudEvents:type":        "matriz.empresas.EmpresaCriada",
+		"cloudEvents:subject":     ev.AggregateID,
+		"cloudEvents:time":        "2024-05-01T12:00:00Z",
+	}
+	if !reflect.DeepEqual(msg.Headers, want) {
+		t.Errorf("headers = %v, want %v", msg.Headers, want)
+	}
+	got, err := DecodeEvent(amqp.Delivery{ContentType: msg.ContentType, Body: msg.Body})
+	if err != nil || !reflect.DeepEqual(got, ev) {
+		t.Errorf("DecodeEvent = %+v, %v, want %+v", got, err, ev)
+	}
+}
+
+func TestPublishingStructured(t *testing.T) {
+	ev := testEvent()
+	msg, err := newPublishing(ev, ModeStructured, "/teste")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentType != StructuredContentType || len(msg.Headers) != 0 {
+		t.Errorf("content-type %q, headers %v", msg.ContentType, msg.Headers)
+	}
+	var ce map[string]interface{}
+	if err := json.Unmarshal(msg.Body, &ce); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              ev.ID,
+		"source":          "/teste",
+		"type":            "matriz.empresas.EmpresaCriada",
+		"subject":         ev.AggregateID,
+		"time":            "2024-05-01T12:00:00Z",
+		"datacontenttype": "application/json",
+	}
+	for k, v := range want {
+		if ce[k] != v {
+			t.Errorf("%s = %v, want %v", k, ce[k], v)
+		}
+	}
+	got, err := DecodeEvent(amqp.Delivery{ContentType: msg.ContentType, Body: msg.Body})
+	if err != nil || !reflect.DeepEqual(got, ev) {
+		t.Errorf("DecodeEvent = %+v, %v, want %+v", got, err, ev)
+	}
+}
+
+func TestDecodeEventContentType(t *testing.T) {
+	if _, err := DecodeEvent(amqp.Delivery{ContentType: "text/plain", Body: []byte("Cadastro de EMPRESA Padaria")}); err == nil {
+		t.Error("DecodeEvent aceitou mensagem de texto")
+	}
+}
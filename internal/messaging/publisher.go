@@ -0,0 +1,94 @@
+package messaging
+
+import (
+	"log"
+	"sync"
+
+	"matriz/internal/events"
+)
+
+// EventPublisher publica os eventos de empresa. Implementações: Publisher
+// (RabbitMQ), ChannelBroker (em processo), LogPublisher (só log) e
+// RecordingPublisher (testes).
+type EventPublisher interface {
+	// Publish publica ev; um erro indica que ele pode não ter chegado aos
+	// consumidores.
+	Publish(ev events.Event) error
+	// Close libera os recursos do publisher.
+	Close()
+}
+
+var (
+	_ EventPublisher = (*Publisher)(nil)
+	_ EventPublisher = (*ChannelBroker)(nil)
+	_ EventPublisher = (*LogPublisher)(nil)
+	_ EventPublisher = (*RecordingPublisher)(nil)
+)
+
+// LogPublisher só registra os eventos no log, para rodar sem broker.
+type LogPublisher struct {
+	logger *log.Logger
+}
+
+// NewLogPublisher cria um LogPublisher que escreve em logger; nil usa o
+// logger padrão do pacote log.
+func NewLogPublisher(logger *log.Logger) *LogPublisher {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return &LogPublisher{logger: logger}
+}
+
+// Publish registra ev no log.
+func (p *LogPublisher) Publish(ev events.Event) error {
+	p.logger.Printf("evento %s %s (%s): %s", ev.Type, ev.ID, RoutingKey(ev), ev.Message)
+	return nil
+}
+
+// Close não faz nada.
+func (p *LogPublisher) Close() {}
+
+// RecordingPublisher guarda os eventos publicados, para testes. O valor
+// zero está pronto para uso.
+type RecordingPublisher struct {
+	mu     sync.Mutex
+	events []events.Event
+	err    error
+	closed bool
+}
+
+// Publish guarda ev ou, se houver um erro definido por Fail, o devolve sem
+// guardar o evento.
+func (p *RecordingPublisher) Publish(ev events.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.events = append(p.events, ev)
+	return nil
+}
+
+// Events devolve uma cópia dos eventos publicados, em ordem.
+func (p *RecordingPublisher) Events() []events.Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]events.Event(nil), p.events...)
+}
+
+// Fail faz as próximas publicações falharem com err; nil volta a aceitá-las.
+func (p *RecordingPublisher) Fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+}
+
+// Close faz as próximas publicações falharem com ErrClosed.
+func (p *RecordingPublisher) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+}
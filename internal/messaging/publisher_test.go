@@ -0,0 +1,93 @@
+package messaging
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"matriz/internal/events"
+)
+
+func TestLogPublisher(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewLogPublisher(log.New(&buf, "", 0))
+	if err := p.Publish(testEvent()); err != nil {
+		t.Fatal(err)
+	}
+	want := "evento EmpresaCriada 665f1c2b8a1e4d0012345678-1 (empresa.created): Cadastro de EMPRESA Padaria\n"
+	if buf.String() != want {
+		t.Errorf("log = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestRecordingPublisher(t *testing.T) {
+	var p RecordingPublisher
+	publishIDs(t, &p, "1")
+	boom := errors.New("boom")
+	p.Fail(boom)
+	if err := p.Publish(events.Event{ID: "2"}); !errors.Is(err, boom) {
+		t.Errorf("Publish após Fail = %v, want %v", err, boom)
+	}
+	p.Fail(nil)
+	publishIDs(t, &p, "3")
+	if got := p.Events(); len(got) != 2 || got[0].ID != "1" || got[1].ID != "3" {
+		t.Errorf("Events = %+v", got)
+	}
+	p.Close()
+	if err := p.Publish(events.Event{ID: "4"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish após Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestChannelBroker(t *testing.T) {
+	b := NewChannelBroker()
+	all, cancelAll := b.Subscribe(10)
+	defer cancelAll()
+	slow, cancelSlow := b.Subscribe(1)
+
+	if err := b.Publish(events.Event{ID: "1"}); err != nil {
+		t.Fatalf("Publish = %v", err)
+	}
+	// slow está cheio: só ele perde o evento
+	err := b.Publish(events.Event{ID: "2"})
+	if !errors.Is(err, ErrSlowSubscriber) || !strings.Contains(err.Error(), "1 inscrito") {
+		t.Errorf("Publish com inscrito cheio = %v", err)
+	}
+	if ev := <-all; ev.ID != "1" {
+		t.Errorf("all recebeu %s", ev.ID)
+	}
+	if ev := <-all; ev.ID != "2" {
+		t.Errorf("all recebeu %s", ev.ID)
+	}
+	if ev := <-slow; ev.ID != "1" {
+		t.Errorf("slow recebeu %s", ev.ID)
+	}
+
+	// após cancelar, slow é fechado e não recebe mais nada
+	cancelSlow()
+	cancelSlow()
+	if _, ok := <-slow; ok {
+		t.Error("canal de inscrição cancelada continua aberto")
+	}
+	if err := b.Publish(events.Event{ID: "3"}); err != nil {
+		t.Errorf("Publish = %v", err)
+	}
+
+	b.Close()
+	if ev, ok := <-all; !ok || ev.ID != "3" {
+		t.Errorf("all recebeu %s, %t", ev.ID, ok)
+	}
+	if _, ok := <-all; ok {
+		t.Error("canal continua aberto após Close")
+	}
+	if err := b.Publish(events.Event{ID: "4"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish após Close = %v, want ErrClosed", err)
+	}
+	if ch, _ := b.Subscribe(1); ch != nil {
+		if _, ok := <-ch; ok {
+			t.Error("Subscribe após Close devolveu canal aberto")
+		}
+	}
+}
@@ -2,19 +2,241 @@ package messaging
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
+	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
+
+	"matriz/internal/events"
+)
+
+// DefaultExchange é o exchange topic padrão dos eventos de empresa.
+const DefaultExchange = "empresas"
+
+// Chaves de roteamento dos eventos (veja RoutingKey). Consumidores ligam
+// suas filas ao exchange com padrões como "empresa.*" ou "empresa.deleted".
+const (
+	KeyCreated  = "empresa.created"
+	KeyUpdated  = "empresa.updated"
+	KeyDeleted  = "empresa.deleted"
+	KeyRestored = "empresa.restored"
+	KeyPurged   = "empresa.purged"
 )
 
+// RoutingKey devolve a chave de roteamento de ev.
+func RoutingKey(ev events.Event) string {
+	switch ev.Type {
+	case events.EmpresaCriada:
+		return KeyCreated
+	case events.EmpresaExcluida:
+		return KeyDeleted
+	case events.EmpresaRestaurada:
+		return KeyRestored
+	case events.EmpresaExpurgada:
+		return KeyPurged
+	default:
+		return KeyUpdated
+	}
+}
+
+// DeclareExchange declara o exchange topic durável name. Publicador e
+// consumidores o declaram, então qualquer um pode subir primeiro.
+func DeclareExchange(ch *amqp.Channel, name string) error {
+	return ch.ExchangeDeclare(name, amqp.ExchangeTopic, true, false, false, false, nil)
+}
+
+// Erros de Publish.
+var (
+	// ErrClosed indica que o Publisher já foi fechado.
+	ErrClosed = errors.New("messaging: publisher fechado")
+	// ErrBufferFull indica que, sem conexão com o broker, não havia espaço
+	// no buffer para o evento, que foi descartado.
+	ErrBufferFull = errors.New("messaging: sem conexão com o RabbitMQ e sem espaço no buffer")
+	// ErrNacked indica que o broker recusou o evento (basic.nack) e ele não
+	// foi gravado.
+	ErrNacked = errors.New("messaging: evento recusado pelo RabbitMQ")
+	// ErrUnroutable indica que nenhuma fila estava ligada ao exchange com
+	// a chave do evento, que foi devolvido pelo broker (basic.return).
+	ErrUnroutable = errors.New("messaging: evento sem fila de destino")
+)
+
+// State é o estado da conexão de um Publisher.
+type State int32
+
+const (
+	// StateConnecting indica que o Publisher está sem conexão, tentando
+	// conectar; os eventos publicados vão para o buffer.
+	StateConnecting State = iota
+	// StateConnected indica que os eventos são publicados direto no broker.
+	StateConnected
+	// StateClosed indica que o Publisher foi fechado.
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateClosed:
+		return "closed"
+	default:
+		return fmt.Sprintf("State(%d)", int32(s))
+	}
+}
+
+// Valores padrão do Publisher.
+const (
+	DefaultBufferSize = 1000
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// publishTimeout limita cada publicação no broker.
+const publishTimeout = 5 * time.Second
+
+// session é uma conexão aberta com o broker: como publicar nela, a
+// notificação de fechamento do canal (ou da conexão), se ele já fechou e
+// como descartá-la. publish só retorna depois da confirmação do broker.
+type session struct {
+	publish  func(ctx context.Context, m message, mandatory bool) error
+	closed   <-chan *amqp.Error
+	isClosed func() bool
+	close    func()
+}
+
+// message é uma publicação pronta para o broker.
+type message struct {
+	key string
+	msg amqp.Publishing
+}
+
+// request é uma publicação na fila do Publisher. done recebe o resultado
+// quem espera pela confirmação; é nil para os eventos do buffer, que
+// ninguém espera.
+type request struct {
+	m    message
+	done chan error
+}
+
+// Publisher publica os eventos de empresa em um exchange topic, com a
+// chave de roteamento de cada evento. Filas são responsabilidade dos
+// consumidores.
+//
+// O Publisher mantém a conexão sozinho: se ela cair (ou não puder ser
+// aberta), ele reconecta em segundo plano com backoff exponencial e jitter e,
+// enquanto isso, guarda os eventos em um buffer limitado, publicados em
+// ordem assim que a conexão volta. O buffer fica em memória: eventos nele
+// se perdem se o processo terminar (use o outbox quando isso importar).
+//
+// Uma única goroutine (run) é dona da sessão e publica a fila em ordem;
+// Publish só trava o Publisher para enfileirar e espera a confirmação fora
+// dele, então State e publicações concorrentes nunca ficam presas atrás de
+// um broker lento.
 type Publisher struct {
-	conn *amqp.Connection
-	ch   *amqp.Channel
-	q    amqp.Queue
+	dial       func() (*session, error)
+	exchange   string
+	mode       Mode
+	source     string
+	bufSize    int
+	mandatory  bool
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	mu    sync.Mutex
+	state State
+	// queue são as publicações ainda não feitas, em ordem: com conexão, as
+	// que aguardam confirmação; sem conexão, o buffer. Só run remove itens.
+	queue []*request
+	wake  chan struct{} // avisa run de novos itens em queue
+
+	ctx    context.Context // cancelado por Close
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Option configura um Publisher em NewPublisher.
+type Option func(*Publisher)
+
+// WithMode define o modo CloudEvents das mensagens. O padrão é ModeBinary.
+func WithMode(m Mode) Option {
+	return func(p *Publisher) { p.mode = m }
+}
+
+// WithSource define o atributo source dos CloudEvents; vazio mantém
+// DefaultSource.
+func WithSource(source string) Option {
+	return func(p *Publisher) {
+		if source != "" {
+			p.source = source
+		}
+	}
+}
+
+// WithMandatory define se os eventos são publicados com a flag mandatory,
+// fazendo Publish falhar com ErrUnroutable quando nenhuma fila os recebe.
+// O padrão é true; use false se for aceitável publicar sem consumidores.
+func WithMandatory(mandatory bool) Option {
+	return func(p *Publisher) { p.mandatory = mandatory }
+}
+
+// WithBufferSize define quantos eventos são guardados enquanto não há
+// conexão; 0 desabilita o buffer e Publish falha com ErrBufferFull. O
+// padrão é DefaultBufferSize.
+func WithBufferSize(n int) Option {
+	return func(p *Publisher) { p.bufSize = n }
+}
+
+// WithBackoff define a espera antes da primeira nova tentativa de conexão e
+// o limite para o qual ela dobra a cada falha seguida; cada espera é
+// sorteada entre metade e o total do valor. O padrão é DefaultMinBackoff e
+// DefaultMaxBackoff.
+func WithBackoff(first, limit time.Duration) Option {
+	return func(p *Publisher) { p.minBackoff, p.maxBackoff = first, limit }
+}
+
+// NewPublisher cria um Publisher para o exchange do RabbitMQ em url. A
+// primeira conexão é tentada antes de retornar; se falhar, o Publisher é
+// devolvido mesmo assim e continua tentando em segundo plano (veja State).
+func NewPublisher(url string, exchange string, opts ...Option) *Publisher {
+	return newPublisher(exchange, func() (*session, error) { return dial(url, exchange) }, opts...)
+}
+
+func newPublisher(exchange string, dial func() (*session, error), opts ...Option) *Publisher {
+	p := &Publisher{
+		dial:       dial,
+		exchange:   exchange,
+		mode:       ModeBinary,
+		source:     DefaultSource,
+		bufSize:    DefaultBufferSize,
+		mandatory:  true,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		wake:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	for _, opt := range opts {
+		opt(p)
+	}
+	s, err := dial()
+	if err != nil {
+		log.Printf("rabbit: conexão: %v; tentando em segundo plano", err)
+	} else {
+		p.state = StateConnected
+	}
+	go p.run(s)
+	return p
 }
 
-func NewPublisher(url string, queue string) (*Publisher, error) {
+// dial abre uma conexão com o RabbitMQ em url, declara o exchange e
+// habilita as confirmações de publicação (publisher confirms) no canal.
+func dial(url, exchange string) (*session, error) {
 	conn, err := amqp.Dial(url)
 	if err != nil {
 		return nil, err
@@ -24,33 +246,297 @@ func NewPublisher(url string, queue string) (*Publisher, error) {
 		conn.Close()
 		return nil, err
 	}
-	q, err := ch.QueueDeclare(queue, true, false, false, false, nil)
-	if err != nil {
+	if err := DeclareExchange(ch, exchange); err != nil {
 		ch.Close()
 		conn.Close()
 		return nil, err
 	}
-	return &Publisher{conn: conn, ch: ch, q: q}, nil
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		conn.Close()
+		return nil, err
+	}
+	// folga para devoluções de publicações cuja espera expirou: o canal
+	// trava se não houver espaço para uma devolução
+	returns := ch.NotifyReturn(make(chan amqp.Return, 16))
+	return &session{
+		publish: func(ctx context.Context, m message, mandatory bool) error {
+			return confirmedPublish(ctx, ch, returns, exchange, m, mandatory)
+		},
+		closed:   ch.NotifyClose(make(chan *amqp.Error, 1)),
+		isClosed: ch.IsClosed,
+		close: func() {
+			// a conexão pode já ter caído; os erros de fechamento não importam
+			_ = ch.Close()
+			_ = conn.Close()
+		},
+	}, nil
+}
+
+// run é a goroutine que publica: serve a fila na sessão atual s (nil se
+// não houver) e reconecta sempre que ela falha, até Close.
+func (p *Publisher) run(s *session) {
+	defer close(p.done)
+	backoff := p.minBackoff
+	for {
+		if s != nil {
+			err := p.serve(s)
+			s.close()
+			if p.ctx.Err() != nil {
+				break
+			}
+			p.disconnect()
+			log.Printf("rabbit: conexão perdida: %v", err)
+		}
+		select {
+		case <-time.After(jitter(backoff)):
+		case <-p.ctx.Done():
+		}
+		if p.ctx.Err() != nil {
+			break
+		}
+		var err error
+		if s, err = p.dial(); err != nil {
+			backoff = min(2*backoff, p.maxBackoff)
+			log.Printf("rabbit: conexão: %v; nova tentativa em até %s", err, backoff)
+			continue
+		}
+		log.Printf("rabbit: conectado")
+		backoff = p.minBackoff
+	}
+	p.shutdown()
+}
+
+// serve publica a fila na sessão s, em ordem, até s falhar, devolvendo o
+// erro, ou Close. O item que falha fica no início da fila. Após uma
+// reconexão, o estado passa a StateConnected quando o buffer se esvazia.
+func (p *Publisher) serve(s *session) error {
+	for {
+		r := p.next()
+		if r == nil {
+			select {
+			case <-p.wake:
+				continue
+			case err := <-s.closed:
+				if err == nil {
+					return errors.New("canal fechado")
+				}
+				return err
+			case <-p.ctx.Done():
+				return p.ctx.Err()
+			}
+		}
+		err := p.send(s, r.m)
+		if errors.Is(err, ErrNacked) && s.isClosed() {
+			// ao fechar, o canal resolve as confirmações pendentes como nack:
+			// é a queda da conexão, não uma recusa, e o item fica na fila
+			return fmt.Errorf("publicação do evento %s: canal fechado antes da confirmação", r.m.msg.MessageId)
+		}
+		if err != nil && !rejected(err) {
+			return fmt.Errorf("publicação do evento %s: %w", r.m.msg.MessageId, err)
+		}
+		p.pop()
+		if r.done != nil {
+			r.done <- err
+		} else if err != nil {
+			// não há a quem devolver o erro: o evento é descartado
+			log.Printf("rabbit: reenvio do evento %s: %v", r.m.msg.MessageId, err)
+		}
+	}
+}
+
+// next devolve o primeiro item da fila sem removê-lo, ou nil se ela estiver
+// vazia; nesse caso a conexão está pronta e o estado passa a
+// StateConnected.
+func (p *Publisher) next() *request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.queue) == 0 {
+		if p.state == StateConnecting {
+			p.state = StateConnected
+		}
+		return nil
+	}
+	return p.queue[0]
+}
+
+// pop remove o primeiro item da fila.
+func (p *Publisher) pop() {
+	p.mu.Lock()
+	p.queue[0] = nil
+	p.queue = p.queue[1:]
+	p.mu.Unlock()
+}
+
+// disconnect volta ao estado StateConnecting após a queda da sessão. Quem
+// esperava confirmação é liberado: seus eventos passam ao buffer, e os que
+// não couberem nele são descartados com ErrBufferFull.
+func (p *Publisher) disconnect() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = StateConnecting
+	kept := p.queue[:0]
+	for i, r := range p.queue {
+		if len(kept) == p.bufSize {
+			if r.done != nil {
+				r.done <- ErrBufferFull
+			} else {
+				log.Printf("rabbit: evento %s descartado: buffer cheio", r.m.msg.MessageId)
+			}
+			p.queue[i] = nil
+			continue
+		}
+		if r.done != nil {
+			r.done <- nil
+			r.done = nil
+		}
+		kept = append(kept, r)
+	}
+	p.queue = kept
+}
+
+// shutdown esvazia a fila ao fechar: quem esperava confirmação recebe
+// ErrClosed e os eventos do buffer são descartados.
+func (p *Publisher) shutdown() {
+	p.mu.Lock()
+	queue := p.queue
+	p.queue = nil
+	p.mu.Unlock()
+	dropped := 0
+	for _, r := range queue {
+		if r.done != nil {
+			r.done <- ErrClosed
+		} else {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		log.Printf("rabbit: %d eventos no buffer descartados ao fechar", dropped)
+	}
+}
+
+// State devolve o estado atual da conexão.
+func (p *Publisher) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
 }
 
-func (p *Publisher) Publish(msg string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Publish publica ev no exchange como um CloudEvent, no modo configurado,
+// com a chave RoutingKey(ev), e espera a confirmação do broker. Devolve
+// ErrNacked se o broker recusar o evento e ErrUnroutable se nenhuma fila o
+// receber. Sem conexão, ev vai para o buffer e é publicado quando ela
+// voltar; com o buffer cheio, devolve ErrBufferFull.
+func (p *Publisher) Publish(ev events.Event) error {
+	msg, err := newPublishing(ev, p.mode, p.source)
+	if err != nil {
+		return err
+	}
+	// done é guardado fora de r, que disconnect pode alterar
+	var done chan error
+	r := &request{m: message{key: RoutingKey(ev), msg: msg}}
+	p.mu.Lock()
+	switch p.state {
+	case StateClosed:
+		p.mu.Unlock()
+		return ErrClosed
+	case StateConnected:
+		done = make(chan error, 1)
+		r.done = done
+	default:
+		if len(p.queue) >= p.bufSize {
+			p.mu.Unlock()
+			return ErrBufferFull
+		}
+	}
+	p.queue = append(p.queue, r)
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	if done == nil {
+		return nil
+	}
+	return <-done
+}
+
+// send publica m na sessão s e espera a confirmação.
+func (p *Publisher) send(s *session, m message) error {
+	ctx, cancel := context.WithTimeout(p.ctx, publishTimeout)
 	defer cancel()
-	return p.ch.PublishWithContext(ctx, "", p.q.Name, false, false, amqp.Publishing{
-		ContentType: "text/plain",
-		Body:        []byte(msg),
-		DeliveryMode: amqp.Persistent,
-	})
+	return s.publish(ctx, m, p.mandatory)
+}
+
+// rejected informa se err é uma recusa do broker a um evento, e não uma
+// falha da conexão.
+func rejected(err error) bool {
+	return errors.Is(err, ErrNacked) || errors.Is(err, ErrUnroutable)
+}
+
+// confirmedPublish publica m em ch, um canal em modo confirm, e espera o
+// ack. O broker envia a devolução de uma mensagem mandatory sem destino
+// antes do ack dela, então, quando o ack chega, a devolução já está em
+// returns. As publicações no canal são serializadas pelo Publisher.
+func confirmedPublish(ctx context.Context, ch *amqp.Channel, returns <-chan amqp.Return, exchange string, m message, mandatory bool) error {
+	drainReturns(returns, "")
+	dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, exchange, m.key, mandatory, false, m.msg)
+	if err != nil {
+		return err
+	}
+	acked, err := dc.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("%w: evento %s", ErrNacked, m.msg.MessageId)
+	}
+	if r, ok := drainReturns(returns, m.msg.MessageId); ok {
+		return fmt.Errorf("%w: evento %s (%s, chave %s)", ErrUnroutable, m.msg.MessageId, r.ReplyText, r.RoutingKey)
+	}
+	return nil
+}
+
+// drainReturns esvazia returns e devolve a devolução da mensagem id, se
+// houver; as demais são de publicações anteriores cuja espera expirou.
+// returns é fechado junto com o canal.
+func drainReturns(returns <-chan amqp.Return, id string) (amqp.Return, bool) {
+	var found amqp.Return
+	var ok bool
+	for {
+		select {
+		case r, open := <-returns:
+			if !open {
+				return found, ok
+			}
+			if id != "" && r.MessageId == id {
+				found, ok = r, true
+			}
+		default:
+			return found, ok
+		}
+	}
 }
 
+// Close encerra a conexão e as novas tentativas. Eventos ainda no buffer
+// são descartados, e publicações esperando confirmação recebem ErrClosed.
 func (p *Publisher) Close() {
 	if p == nil {
 		return
 	}
-	if err := p.ch.Close(); err != nil {
-		log.Printf("rabbit channel close error: %v", err)
-	}
-	if err := p.conn.Close(); err != nil {
-		log.Printf("rabbit conn close error: %v", err)
+	p.mu.Lock()
+	if p.state == StateClosed {
+		p.mu.Unlock()
+		return
 	}
+	p.state = StateClosed
+	p.mu.Unlock()
+	p.cancel()
+	<-p.done
+}
+
+// jitter sorteia uma espera entre d/2 e d, para que instâncias derrubadas
+// juntas não reconectem todas ao mesmo tempo.
+func jitter(d time.Duration) time.Duration {
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
 }
@@ -0,0 +1,316 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"matriz/internal/events"
+)
+
+func TestRoutingKey(t *testing.T) {
+	cases := map[string]string{
+		events.EmpresaCriada:     "empresa.created",
+		events.EmpresaAtualizada: "empresa.updated",
+		events.EmpresaExcluida:   "empresa.deleted",
+		events.EmpresaRestaurada: "empresa.restored",
+	}
+	for typ, want := range cases {
+		if got := RoutingKey(events.Event{Type: typ}); got != want {
+			t.Errorf("RoutingKey(%s) = %q, want %q", typ, got, want)
+		}
+	}
+}
+
+// fakeBroker simula o RabbitMQ: up controla se dial conecta, drop derruba
+// a conexão atual, reject, se definido, é a recusa devolvida às publicações
+// e hold, se definido, segura cada confirmação até ser fechado. Como no
+// amqp091-go, a queda da conexão resolve as confirmações pendentes como
+// nack.
+type fakeBroker struct {
+	mu        sync.Mutex
+	up        bool
+	reject    error
+	hold      chan struct{}
+	calls     int      // publicações iniciadas
+	published []string // MessageId de cada mensagem publicada
+	mandatory []bool
+	closed    chan *amqp.Error
+	dead      chan struct{} // fechado na queda da sessão atual
+}
+
+func (b *fakeBroker) dial() (*session, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.up {
+		return nil, errors.New("connection refused")
+	}
+	closed, dead := make(chan *amqp.Error, 1), make(chan struct{})
+	b.closed, b.dead = closed, dead
+	var once sync.Once
+	return &session{
+		publish: func(ctx context.Context, m message, mandatory bool) error {
+			return b.publish(ctx, dead, m, mandatory)
+		},
+		closed: closed,
+		isClosed: func() bool {
+			select {
+			case <-dead:
+				return true
+			default:
+				return false
+			}
+		},
+		close: func() { once.Do(func() { close(closed) }) },
+	}, nil
+}
+
+func (b *fakeBroker) publish(ctx context.Context, dead <-chan struct{}, m message, mandatory bool) error {
+	b.mu.Lock()
+	b.calls++
+	b.mu.Unlock()
+	if b.hold != nil {
+		select {
+		case <-b.hold:
+		case <-dead:
+			return fmt.Errorf("%w: evento %s", ErrNacked, m.msg.MessageId)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.up {
+		return amqp.ErrClosed
+	}
+	b.mandatory = append(b.mandatory, mandatory)
+	if b.reject != nil {
+		return b.reject
+	}
+	b.published = append(b.published, m.msg.MessageId)
+	return nil
+}
+
+func (b *fakeBroker) setUp(up bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.up = up
+}
+
+// drop derruba a conexão, como um restart do broker.
+func (b *fakeBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.up = false
+	b.closed <- &amqp.Error{Code: amqp.ConnectionForced, Reason: "broker restart"}
+	close(b.dead)
+}
+
+func (b *fakeBroker) messages() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.published...)
+}
+
+func publishIDs(t *testing.T, p EventPublisher, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		if err := p.Publish(events.Event{ID: id, Type: events.EmpresaCriada}); err != nil {
+			t.Fatalf("Publish(%s) = %v", id, err)
+		}
+	}
+}
+
+func waitState(t *testing.T, p *Publisher, want State) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for p.State() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("estado = %s, want %s", p.State(), want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestPublisherReconnect(t *testing.T) {
+	broker := &fakeBroker{}
+	p := newPublisher("empresas", broker.dial, WithBackoff(time.Millisecond, 4*time.Millisecond))
+	defer p.Close()
+
+	// sem broker na subida: os eventos esperam no buffer
+	if p.State() != StateConnecting {
+		t.Fatalf("estado inicial = %s", p.State())
+	}
+	publishIDs(t, p, "1", "2")
+	broker.setUp(true)
+	waitState(t, p, StateConnected)
+	publishIDs(t, p, "3")
+	if got, want := broker.messages(), []string{"1", "2", "3"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("publicadas = %v, want %v", got, want)
+	}
+
+	// restart do broker: reconecta e reenvia em ordem
+	broker.drop()
+	waitState(t, p, StateConnecting)
+	publishIDs(t, p, "4", "5")
+	broker.setUp(true)
+	waitState(t, p, StateConnected)
+	publishIDs(t, p, "6")
+	if got, want := broker.messages(), []string{"1", "2", "3", "4", "5", "6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("publicadas = %v, want %v", got, want)
+	}
+}
+
+func TestPublisherPublishFailure(t *testing.T) {
+	broker := &fakeBroker{up: true}
+	p := newPublisher("empresas", broker.dial, WithBackoff(time.Millisecond, time.Millisecond))
+	defer p.Close()
+	if p.State() != StateConnected {
+		t.Fatalf("estado inicial = %s", p.State())
+	}
+	// o canal falha antes de o fechamento ser notificado: o evento vai para o buffer
+	broker.setUp(false)
+	publishIDs(t, p, "1")
+	if p.State() != StateConnecting {
+		t.Errorf("estado após falha = %s", p.State())
+	}
+	broker.setUp(true)
+	waitState(t, p, StateConnected)
+	if got, want := broker.messages(), []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("publicadas = %v, want %v", got, want)
+	}
+}
+
+func TestPublisherRejected(t *testing.T) {
+	for _, reject := range []error{ErrNacked, ErrUnroutable} {
+		broker := &fakeBroker{up: true, reject: reject}
+		p := newPublisher("empresas", broker.dial)
+		// recusas não são falhas de conexão: o erro vai para quem publicou
+		if err := p.Publish(events.Event{ID: "1"}); !errors.Is(err, reject) {
+			t.Errorf("Publish = %v, want %v", err, reject)
+		}
+		if p.State() != StateConnected || len(p.queue) != 0 {
+			t.Errorf("após %v: estado %s, %d no buffer", reject, p.State(), len(p.queue))
+		}
+		p.Close()
+	}
+}
+
+func TestPublisherSlowConfirm(t *testing.T) {
+	broker := &fakeBroker{up: true, hold: make(chan struct{})}
+	p := newPublisher("empresas", broker.dial)
+	defer p.Close()
+	published := make(chan error, 1)
+	go func() { published <- p.Publish(events.Event{ID: "1"}) }()
+
+	// a espera pela confirmação não trava o Publisher
+	state := make(chan State, 1)
+	go func() { state <- p.State() }()
+	select {
+	case s := <-state:
+		if s != StateConnected {
+			t.Errorf("estado = %s, want connected", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("State travado pela publicação pendente")
+	}
+	close(broker.hold)
+	if err := <-published; err != nil {
+		t.Errorf("Publish = %v", err)
+	}
+	if got, want := broker.messages(), []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("publicadas = %v, want %v", got, want)
+	}
+}
+
+func TestPublisherDropWhileWaiting(t *testing.T) {
+	broker := &fakeBroker{up: true, hold: make(chan struct{})}
+	p := newPublisher("empresas", broker.dial, WithBackoff(time.Millisecond, time.Millisecond))
+	defer p.Close()
+	published := make(chan error, 1)
+	go func() { published <- p.Publish(events.Event{ID: "1"}) }()
+	for {
+		broker.mu.Lock()
+		n := broker.calls
+		broker.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a queda com a confirmação pendente não é uma recusa: o evento vai para
+	// o buffer e é publicado na reconexão
+	broker.drop()
+	if err := <-published; err != nil {
+		t.Errorf("Publish com a conexão caindo = %v, want nil (no buffer)", err)
+	}
+	close(broker.hold)
+	broker.setUp(true)
+	waitState(t, p, StateConnected)
+	if got, want := broker.messages(), []string{"1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("publicadas = %v, want %v", got, want)
+	}
+}
+
+func TestPublisherCloseWhileWaiting(t *testing.T) {
+	broker := &fakeBroker{up: true, hold: make(chan struct{})}
+	p := newPublisher("empresas", broker.dial)
+	published := make(chan error, 1)
+	go func() { published <- p.Publish(events.Event{ID: "1"}) }()
+	for {
+		p.mu.Lock()
+		n := len(p.queue)
+		p.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.Close()
+	if err := <-published; !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish interrompido por Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestPublisherMandatory(t *testing.T) {
+	broker := &fakeBroker{up: true}
+	p := newPublisher("empresas", broker.dial)
+	publishIDs(t, p, "1")
+	p.Close()
+	q := newPublisher("empresas", broker.dial, WithMandatory(false))
+	publishIDs(t, q, "2")
+	q.Close()
+	if want := []bool{true, false}; !reflect.DeepEqual(broker.mandatory, want) {
+		t.Errorf("mandatory = %v, want %v", broker.mandatory, want)
+	}
+}
+
+func TestPublisherBufferFull(t *testing.T) {
+	broker := &fakeBroker{}
+	p := newPublisher("empresas", broker.dial, WithBufferSize(1), WithBackoff(time.Hour, time.Hour))
+	defer p.Close()
+	publishIDs(t, p, "1")
+	if err := p.Publish(events.Event{ID: "2"}); !errors.Is(err, ErrBufferFull) {
+		t.Errorf("Publish com buffer cheio = %v, want ErrBufferFull", err)
+	}
+}
+
+func TestPublisherClose(t *testing.T) {
+	broker := &fakeBroker{up: true}
+	p := newPublisher("empresas", broker.dial)
+	p.Close()
+	p.Close()
+	if p.State() != StateClosed {
+		t.Errorf("estado = %s, want closed", p.State())
+	}
+	if err := p.Publish(events.Event{ID: "1"}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Publish após Close = %v, want ErrClosed", err)
+	}
+}
@@ -1,11 +1,37 @@
 package models
 
+import "time"
+
+// Empresa é o cadastro de uma empresa. CNPJ é armazenado na forma canônica
+// (14 caracteres, sem pontuação, em maiúsculas); CNPJFormatado não é
+// persistido e só é preenchido nas respostas da API, assim como CotaLegalPCD,
+// StatusPCD e DeficitPCD, calculados a partir de NumFuncionarios e
+// NumPCDContratados. CotaLegalPCD é a cota do art. 93 para NumFuncionarios e
+// pode diferir de NumMinPCD, o valor gravado (ex.: com PCD_POLICY=warn);
+// StatusPCD e DeficitPCD usam sempre a cota legal.
+// Version é incrementada pelo repositório a cada alteração e serve de ETag
+// para controle de concorrência otimista. Os campos de auditoria (CreatedAt,
+// CreatedBy, UpdatedAt e UpdatedBy) também são mantidos pelo repositório a
+// partir do principal da requisição e nunca vêm do cliente; UpdatedAt é o
+// Last-Modified da empresa. DeletedAt marca a exclusão lógica e é gravado
+// como null nas empresas ativas.
 type Empresa struct {
-	ID              string `json:"id,omitempty" bson:"_id,omitempty"`
-	CNPJ            string `json:"cnpj" bson:"cnpj"`
-	NomeFantasia    string `json:"nome_fantasia" bson:"nome_fantasia"`
-	RazaoSocial     string `json:"razao_social" bson:"razao_social"`
-	Endereco        string `json:"endereco" bson:"endereco"`
-	NumFuncionarios int    `json:"num_funcionarios" bson:"num_funcionarios"`
-	NumMinPCD       int    `json:"num_min_pcd" bson:"num_min_pcd"`
+	ID                string     `json:"id,omitempty" bson:"_id,omitempty"`
+	CNPJ              string     `json:"cnpj" bson:"cnpj"`
+	CNPJFormatado     string     `json:"cnpj_formatado,omitempty" bson:"-"`
+	NomeFantasia      string     `json:"nome_fantasia" bson:"nome_fantasia"`
+	RazaoSocial       string     `json:"razao_social" bson:"razao_social"`
+	Endereco          string     `json:"endereco" bson:"endereco"`
+	NumFuncionarios   int        `json:"num_funcionarios" bson:"num_funcionarios"`
+	NumMinPCD         int        `json:"num_min_pcd" bson:"num_min_pcd"`
+	NumPCDContratados int        `json:"num_pcd_contratados" bson:"num_pcd_contratados"`
+	CotaLegalPCD      int        `json:"cota_legal_pcd" bson:"-"`
+	StatusPCD         string     `json:"status_pcd,omitempty" bson:"-"`
+	DeficitPCD        int        `json:"deficit_pcd" bson:"-"`
+	Version           int64      `json:"version" bson:"version"`
+	CreatedAt         time.Time  `json:"created_at" bson:"created_at"`
+	CreatedBy         string     `json:"created_by,omitempty" bson:"created_by"`
+	UpdatedAt         time.Time  `json:"updated_at" bson:"updated_at"`
+	UpdatedBy         string     `json:"updated_by,omitempty" bson:"updated_by"`
+	DeletedAt         *time.Time `json:"deleted_at,omitempty" bson:"deleted_at"`
 }
@@ -0,0 +1,173 @@
+// Package outbox publica os eventos gravados no outbox do repositório
+// (veja repository.Outbox): um Relay lê as entradas pendentes em ordem,
+// publica cada uma e a marca como entregue, tentando de novo com backoff
+// exponencial enquanto o broker estiver indisponível. A entrega é "pelo
+// menos uma vez": um evento publicado cuja marcação falhe é publicado de
+// novo. Eventos sem fila de destino (messaging.ErrUnroutable) são
+// descartados, para não segurar os seguintes.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"matriz/internal/events"
+	"matriz/internal/messaging"
+	"matriz/internal/repository"
+)
+
+// Publisher é o destino dos eventos (ex.: *messaging.Publisher).
+type Publisher interface {
+	Publish(ev events.Event) error
+	Close()
+}
+
+// DialFunc abre uma conexão com o broker. O Relay a chama na primeira
+// entrega e de novo depois de cada falha de publicação.
+type DialFunc func() (Publisher, error)
+
+// Valores padrão do Relay.
+const (
+	DefaultInterval   = time.Second
+	DefaultBatchSize  = 100
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = time.Minute
+)
+
+// Relay publica os eventos pendentes do outbox.
+type Relay struct {
+	store      repository.Outbox
+	dial       DialFunc
+	pub        Publisher
+	interval   time.Duration
+	batch      int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// Option configura um Relay em NewRelay.
+type Option func(*Relay)
+
+// WithInterval define o intervalo entre consultas ao outbox quando não há
+// falhas. O padrão é DefaultInterval.
+func WithInterval(d time.Duration) Option {
+	return func(r *Relay) { r.interval = d }
+}
+
+// WithBatchSize define quantos eventos são lidos por consulta. O padrão é
+// DefaultBatchSize.
+func WithBatchSize(n int) Option {
+	return func(r *Relay) { r.batch = n }
+}
+
+// WithBackoff define a espera após a primeira falha e o limite para o qual
+// ela dobra a cada falha seguida. O padrão é DefaultMinBackoff e
+// DefaultMaxBackoff.
+func WithBackoff(first, limit time.Duration) Option {
+	return func(r *Relay) { r.minBackoff, r.maxBackoff = first, limit }
+}
+
+// NewRelay cria um Relay que lê os eventos de store e os publica nas
+// conexões abertas por dial.
+func NewRelay(store repository.Outbox, dial DialFunc, opts ...Option) *Relay {
+	r := &Relay{
+		store:      store,
+		dial:       dial,
+		interval:   DefaultInterval,
+		batch:      DefaultBatchSize,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run entrega os eventos pendentes até ctx ser cancelado, quando fecha a
+// conexão com o broker.
+func (r *Relay) Run(ctx context.Context) {
+	defer r.closePublisher()
+	backoff := r.minBackoff
+	for {
+		n, err := r.Flush(ctx)
+		wait := r.interval
+		switch {
+		case err != nil:
+			log.Printf("outbox: %v; nova tentativa em %s", err, backoff)
+			wait = backoff
+			backoff = min(2*backoff, r.maxBackoff)
+		case n == r.batch:
+			// lote cheio: provavelmente há mais eventos pendentes
+			backoff, wait = r.minBackoff, 0
+		default:
+			backoff = r.minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// Flush publica, em ordem, um lote de eventos pendentes e devolve quantos
+// foram entregues. Para no primeiro evento que não puder ser publicado, para
+// não entregar os seguintes fora de ordem, registrando a falha nele. A
+// exceção são os eventos sem fila de destino: tentar de novo não adianta
+// enquanto nenhum consumidor ligar uma fila, então o evento é marcado como
+// entregue, com a falha registrada, e o relay segue para o próximo.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	entries, err := r.store.PendingEvents(ctx, r.batch)
+	if err != nil {
+		return 0, fmt.Errorf("leitura do outbox: %w", err)
+	}
+	for i, entry := range entries {
+		err := r.publish(events.FromHistory(entry.Event))
+		if errors.Is(err, messaging.ErrUnroutable) {
+			log.Printf("outbox: evento %s descartado: %v", entry.ID, err)
+			if mErr := r.store.MarkFailed(ctx, entry.ID, err); mErr != nil {
+				log.Printf("outbox: registro de falha do evento %s: %v", entry.ID, mErr)
+			}
+			err = nil
+		}
+		if err != nil {
+			if mErr := r.store.MarkFailed(ctx, entry.ID, err); mErr != nil {
+				log.Printf("outbox: registro de falha do evento %s: %v", entry.ID, mErr)
+			}
+			return i, fmt.Errorf("publicação do evento %s: %w", entry.ID, err)
+		}
+		if err := r.store.MarkDelivered(ctx, entry.ID); err != nil {
+			return i, fmt.Errorf("marcação do evento %s: %w", entry.ID, err)
+		}
+	}
+	return len(entries), nil
+}
+
+// publish publica ev, conectando ao broker se necessário. Depois de uma
+// falha a conexão é descartada e refeita na próxima tentativa; evento sem
+// fila de destino não é falha da conexão, que é mantida.
+func (r *Relay) publish(ev events.Event) error {
+	if r.pub == nil {
+		pub, err := r.dial()
+		if err != nil {
+			return fmt.Errorf("conexão com o broker: %w", err)
+		}
+		r.pub = pub
+	}
+	if err := r.pub.Publish(ev); err != nil && !errors.Is(err, messaging.ErrUnroutable) {
+		r.closePublisher()
+		return err
+	}
+	return nil
+}
+
+func (r *Relay) closePublisher() {
+	if r.pub != nil {
+		r.pub.Close()
+		r.pub = nil
+	}
+}
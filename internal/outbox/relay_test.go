@@ -0,0 +1,184 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"matriz/internal/events"
+	"matriz/internal/messaging"
+	"matriz/internal/models"
+	"matriz/internal/repository"
+)
+
+// fakeBroker registra as mensagens publicadas; failNext faz as próximas
+// publicações falharem e unroutable as devolve sem fila de destino.
+type fakeBroker struct {
+	mu         sync.Mutex
+	msgs       []string // Message de cada evento publicado
+	failNext   int
+	unroutable int
+	dials      int
+	closed     int
+}
+
+func (b *fakeBroker) dial() (Publisher, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.dials++
+	return b, nil
+}
+
+func (b *fakeBroker) Publish(ev events.Event) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failNext > 0 {
+		b.failNext--
+		return errors.New("canal fechado")
+	}
+	if b.unroutable > 0 {
+		b.unroutable--
+		return fmt.Errorf("%w: evento %s", messaging.ErrUnroutable, ev.ID)
+	}
+	b.msgs = append(b.msgs, ev.Message)
+	return nil
+}
+
+func (b *fakeBroker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed++
+}
+
+func (b *fakeBroker) published() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.msgs...)
+}
+
+// seed cria, altera e exclui uma empresa, gerando três eventos no outbox.
+func seed(t *testing.T, repo *repository.MemoryEmpresaRepo) {
+	t.Helper()
+	ctx := context.Background()
+	e := models.Empresa{CNPJ: "04252011000110", NomeFantasia: "Padaria"}
+	id, err := repo.Create(ctx, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.NomeFantasia = "Padaria Nova"
+	if err := repo.Update(ctx, id, &e, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Delete(ctx, id, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+}
+
+var seedMsgs = []string{
+	"Cadastro de EMPRESA Padaria",
+	"Edição da EMPRESA Padaria Nova",
+	"Exclusão da EMPRESA Padaria Nova",
+}
+
+func TestFlush(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryEmpresaRepo(repository.WithOutbox())
+	seed(t, repo)
+	broker := &fakeBroker{failNext: 1}
+	relay := NewRelay(repo, broker.dial)
+
+	// a primeira publicação falha: nada é entregue e a falha fica registrada
+	if n, err := relay.Flush(ctx); n != 0 || err == nil {
+		t.Fatalf("Flush com falha = %d, %v, want 0 e erro", n, err)
+	}
+	pending, _ := repo.PendingEvents(ctx, 10)
+	if len(pending) != 3 || pending[0].Attempts != 1 || pending[0].LastError != "canal fechado" {
+		t.Fatalf("pendentes após falha = %+v", pending)
+	}
+	if broker.closed != 1 {
+		t.Errorf("conexão não foi descartada após a falha: %d fechamentos", broker.closed)
+	}
+
+	if n, err := relay.Flush(ctx); n != 3 || err != nil {
+		t.Fatalf("Flush = %d, %v, want 3", n, err)
+	}
+	if got := broker.published(); !reflect.DeepEqual(got, seedMsgs) {
+		t.Errorf("publicadas = %q, want %q", got, seedMsgs)
+	}
+	if broker.dials != 2 {
+		t.Errorf("conexões = %d, want 2", broker.dials)
+	}
+	if pending, _ := repo.PendingEvents(ctx, 10); len(pending) != 0 {
+		t.Errorf("pendentes após entrega = %d", len(pending))
+	}
+	if n, err := relay.Flush(ctx); n != 0 || err != nil {
+		t.Errorf("Flush sem pendentes = %d, %v", n, err)
+	}
+}
+
+func TestFlushUnroutable(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryEmpresaRepo(repository.WithOutbox())
+	seed(t, repo)
+	broker := &fakeBroker{unroutable: 1}
+	relay := NewRelay(repo, broker.dial)
+
+	// o evento sem fila é descartado e não segura os seguintes
+	if n, err := relay.Flush(ctx); n != 3 || err != nil {
+		t.Fatalf("Flush = %d, %v, want 3", n, err)
+	}
+	if got := broker.published(); !reflect.DeepEqual(got, seedMsgs[1:]) {
+		t.Errorf("publicadas = %q, want %q", got, seedMsgs[1:])
+	}
+	if pending, _ := repo.PendingEvents(ctx, 10); len(pending) != 0 {
+		t.Errorf("pendentes = %+v, want nenhum", pending)
+	}
+	if broker.dials != 1 || broker.closed != 0 {
+		t.Errorf("%d conexões e %d fechamentos, want a mesma conexão aberta", broker.dials, broker.closed)
+	}
+}
+
+func TestFlushDialError(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryEmpresaRepo(repository.WithOutbox())
+	seed(t, repo)
+	relay := NewRelay(repo, func() (Publisher, error) { return nil, errors.New("connection refused") })
+	if n, err := relay.Flush(ctx); n != 0 || err == nil {
+		t.Fatalf("Flush sem broker = %d, %v, want 0 e erro", n, err)
+	}
+	pending, _ := repo.PendingEvents(ctx, 10)
+	if len(pending) != 3 || pending[0].Attempts != 1 || pending[1].Attempts != 0 {
+		t.Errorf("pendentes = %+v", pending)
+	}
+}
+
+func TestRun(t *testing.T) {
+	repo := repository.NewMemoryEmpresaRepo(repository.WithOutbox())
+	seed(t, repo)
+	broker := &fakeBroker{failNext: 2}
+	relay := NewRelay(repo, broker.dial,
+		WithInterval(time.Millisecond), WithBatchSize(2), WithBackoff(time.Millisecond, 2*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		relay.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(broker.published()) < len(seedMsgs) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if got := broker.published(); !reflect.DeepEqual(got, seedMsgs) {
+		t.Errorf("publicadas = %q, want %q", got, seedMsgs)
+	}
+	if broker.closed != broker.dials {
+		t.Errorf("Run terminou com conexão aberta: %d conexões, %d fechamentos", broker.dials, broker.closed)
+	}
+}
@@ -0,0 +1,108 @@
+// Package pcd implementa a reserva de vagas para pessoas com deficiência
+// (PCD) prevista no art. 93 da Lei 8.213/91.
+package pcd
+
+import (
+	"fmt"
+
+	"matriz/internal/models"
+)
+
+// faixa associa um limite superior de empregados ao percentual exigido.
+type faixa struct {
+	ate        int // inclusive; 0 indica sem limite
+	percentual int
+}
+
+// faixas do art. 93: 100 a 200 empregados, 2%; 201 a 500, 3%;
+// 501 a 1.000, 4%; a partir de 1.001, 5%.
+var faixas = []faixa{
+	{ate: 200, percentual: 2},
+	{ate: 500, percentual: 3},
+	{ate: 1000, percentual: 4},
+	{ate: 0, percentual: 5},
+}
+
+// MinimoEmpregados é o número de empregados a partir do qual a cota se aplica.
+const MinimoEmpregados = 100
+
+// Percentual retorna o percentual da cota para o número de empregados
+// informado, ou 0 quando a empresa não está obrigada.
+func Percentual(numFuncionarios int) int {
+	if numFuncionarios < MinimoEmpregados {
+		return 0
+	}
+	for _, f := range faixas {
+		if f.ate == 0 || numFuncionarios <= f.ate {
+			return f.percentual
+		}
+	}
+	return 0
+}
+
+// CotaMinima retorna o número mínimo de empregados PCD exigido. Frações são
+// arredondadas para cima, já que a cota não pode ser cumprida parcialmente.
+func CotaMinima(numFuncionarios int) int {
+	p := Percentual(numFuncionarios)
+	return (numFuncionarios*p + 99) / 100
+}
+
+// Policy define como o num_min_pcd informado pelo cliente é tratado.
+type Policy string
+
+const (
+	// PolicyCompute ignora o valor informado e grava a cota calculada.
+	PolicyCompute Policy = "compute"
+	// PolicyValidate rejeita valores diferentes da cota calculada.
+	PolicyValidate Policy = "validate"
+	// PolicyWarn grava o valor informado e devolve um aviso se divergir.
+	PolicyWarn Policy = "warn"
+)
+
+// ParsePolicy converte o valor de configuração em Policy.
+// String vazia resulta em PolicyCompute.
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case "":
+		return PolicyCompute, nil
+	case PolicyCompute, PolicyValidate, PolicyWarn:
+		return p, nil
+	default:
+		return "", fmt.Errorf("política de cota PCD inválida: %q (use compute, validate ou warn)", s)
+	}
+}
+
+// Status indica a situação de uma empresa em relação à cota PCD.
+type Status string
+
+const (
+	// StatusIsenta indica empresa com menos de MinimoEmpregados empregados.
+	StatusIsenta Status = "isenta"
+	// StatusConforme indica que a cota legal está preenchida.
+	StatusConforme Status = "conforme"
+	// StatusNaoConforme indica contratações abaixo da cota legal.
+	StatusNaoConforme Status = "nao_conforme"
+)
+
+// Avaliar compara as contratações PCD com a cota legal e devolve a situação
+// e o déficit (vagas PCD que faltam preencher; 0 quando conforme).
+func Avaliar(numFuncionarios, contratados int) (Status, int) {
+	cota := CotaMinima(numFuncionarios)
+	switch {
+	case cota == 0:
+		return StatusIsenta, 0
+	case contratados >= cota:
+		return StatusConforme, 0
+	default:
+		return StatusNaoConforme, cota - contratados
+	}
+}
+
+// Preencher calcula os campos PCD derivados de e, que não são persistidos:
+// CotaLegalPCD, StatusPCD e DeficitPCD.
+func Preencher(e *models.Empresa) {
+	e.CotaLegalPCD = CotaMinima(e.NumFuncionarios)
+	status, deficit := Avaliar(e.NumFuncionarios, e.NumPCDContratados)
+	e.StatusPCD = string(status)
+	e.DeficitPCD = deficit
+}
@@ -0,0 +1,73 @@
+package pcd
+
+import "testing"
+
+func TestCotaMinima(t *testing.T) {
+	tests := []struct {
+		funcionarios int
+		percentual   int
+		want         int
+	}{
+		{funcionarios: 0, percentual: 0, want: 0},
+		{funcionarios: 99, percentual: 0, want: 0},
+		{funcionarios: 100, percentual: 2, want: 2},
+		{funcionarios: 101, percentual: 2, want: 3},
+		{funcionarios: 150, percentual: 2, want: 3},
+		{funcionarios: 200, percentual: 2, want: 4},
+		{funcionarios: 201, percentual: 3, want: 7},
+		{funcionarios: 500, percentual: 3, want: 15},
+		{funcionarios: 501, percentual: 4, want: 21},
+		{funcionarios: 1000, percentual: 4, want: 40},
+		{funcionarios: 1001, percentual: 5, want: 51},
+		{funcionarios: 10000, percentual: 5, want: 500},
+	}
+	for _, tt := range tests {
+		if got := Percentual(tt.funcionarios); got != tt.percentual {
+			t.Errorf("Percentual(%d) = %d, want %d", tt.funcionarios, got, tt.percentual)
+		}
+		if got := CotaMinima(tt.funcionarios); got != tt.want {
+			t.Errorf("CotaMinima(%d) = %d, want %d", tt.funcionarios, got, tt.want)
+		}
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Policy
+		wantErr bool
+	}{
+		{in: "", want: PolicyCompute},
+		{in: "compute", want: PolicyCompute},
+		{in: "validate", want: PolicyValidate},
+		{in: "warn", want: PolicyWarn},
+		{in: "ignore", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %q, %v; want %q, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestAvaliar(t *testing.T) {
+	tests := []struct {
+		funcionarios int
+		contratados  int
+		status       Status
+		deficit      int
+	}{
+		{funcionarios: 99, contratados: 0, status: StatusIsenta, deficit: 0},
+		{funcionarios: 100, contratados: 0, status: StatusNaoConforme, deficit: 2},
+		{funcionarios: 100, contratados: 2, status: StatusConforme, deficit: 0},
+		{funcionarios: 201, contratados: 6, status: StatusNaoConforme, deficit: 1},
+		{funcionarios: 1001, contratados: 60, status: StatusConforme, deficit: 0},
+	}
+	for _, tt := range tests {
+		status, deficit := Avaliar(tt.funcionarios, tt.contratados)
+		if status != tt.status || deficit != tt.deficit {
+			t.Errorf("Avaliar(%d, %d) = %q, %d; want %q, %d", tt.funcionarios, tt.contratados, status, deficit, tt.status, tt.deficit)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"matriz/internal/validation"
+)
+
+// PatchFields são os campos (nomes bson) aceitos em Changes.
+var PatchFields = map[string]bool{
+	"cnpj":                true,
+	"nome_fantasia":       true,
+	"razao_social":        true,
+	"endereco":            true,
+	"num_funcionarios":    true,
+	"num_min_pcd":         true,
+	"num_pcd_contratados": true,
+}
+
+// Changes descreve uma alteração parcial de uma empresa: Set grava os
+// valores informados e Unset remove os campos do documento. As chaves são
+// os nomes bson dos campos (veja PatchFields).
+type Changes struct {
+	Set   map[string]interface{}
+	Unset []string
+}
+
+// Empty indica se não há nada a alterar.
+func (c Changes) Empty() bool {
+	return len(c.Set) == 0 && len(c.Unset) == 0
+}
+
+// normalize valida os campos e devolve uma cópia com o CNPJ normalizado.
+func (c Changes) normalize() (Changes, error) {
+	out := Changes{Set: make(map[string]interface{}, len(c.Set)), Unset: c.Unset}
+	for k, v := range c.Set {
+		if !PatchFields[k] {
+			return out, fmt.Errorf("%w: campo %q", ErrInvalidChange, k)
+		}
+		if s, ok := v.(string); ok && k == "cnpj" {
+			v = validation.NormalizeCNPJ(s)
+		}
+		out.Set[k] = v
+	}
+	for _, k := range c.Unset {
+		if !PatchFields[k] {
+			return out, fmt.Errorf("%w: campo %q", ErrInvalidChange, k)
+		}
+		if _, ok := c.Set[k]; ok {
+			return out, fmt.Errorf("%w: campo %q em Set e Unset", ErrInvalidChange, k)
+		}
+	}
+	return out, nil
+}
+
+// update monta o documento de atualização Mongo ($set/$unset).
+func (c Changes) update() bson.M {
+	update := bson.M{}
+	if len(c.Set) > 0 {
+		update["$set"] = bson.M(c.Set)
+	}
+	if len(c.Unset) > 0 {
+		unset := bson.M{}
+		for _, k := range c.Unset {
+			unset[k] = ""
+		}
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// apply aplica as alterações a um documento bson já decodificado.
+func (c Changes) apply(doc bson.M) {
+	for k, v := range c.Set {
+		doc[k] = v
+	}
+	for _, k := range c.Unset {
+		delete(doc, k)
+	}
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// Erros devolvidos pelas implementações de EmpresaStore. Use errors.Is para
+// identificá-los; ErrUnavailable envolve o erro original do driver.
+var (
+	ErrNotFound      = errors.New("não encontrado")
+	ErrDuplicateCNPJ = errors.New("cnpj já cadastrado")
+	ErrInvalidID     = errors.New("id inválido")
+	ErrUnavailable   = errors.New("repositório indisponível")
+	ErrInvalidChange = errors.New("alteração inválida")
+	// ErrVersionConflict indica que a versão esperada numa escrita
+	// condicional não é mais a versão gravada.
+	ErrVersionConflict = errors.New("versão desatualizada")
+)
+
+// parseID converte o ID público (hexadecimal de 24 caracteres) em ObjectID,
+// o formato dos IDs do outbox.
+func parseID(id string) (primitive.ObjectID, error) {
+	obj, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return obj, fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	return obj, nil
+}
+
+// empresaID converte o ID público de uma empresa no valor de _id: ObjectID
+// quando é hexadecimal de 24 caracteres e, como nas versões anteriores do
+// serviço, a própria string nos demais casos, para que documentos gravados
+// com _id string continuem acessíveis. Só o ID vazio é inválido.
+func empresaID(id string) (interface{}, error) {
+	if id == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+	if obj, err := primitive.ObjectIDFromHex(id); err == nil {
+		return obj, nil
+	}
+	return id, nil
+}
+
+// mongoError traduz erros do driver para os erros do pacote. Só a chave
+// duplicada no índice de CNPJ vira ErrDuplicateCNPJ; nas demais (histórico,
+// outbox) o erro é devolvido como está, sendo tratado como erro interno.
+func mongoError(err error) error {
+	var selErr topology.ServerSelectionError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound
+	case mongo.IsDuplicateKeyError(err) && duplicateCNPJ(err):
+		return ErrDuplicateCNPJ
+	case mongo.IsTimeout(err), mongo.IsNetworkError(err),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, mongo.ErrClientDisconnected),
+		errors.As(err, &selErr):
+		return fmt.Errorf("%w: %w", ErrUnavailable, err)
+	default:
+		return err
+	}
+}
+
+// duplicateCNPJ informa se a chave duplicada de err é a do índice único de
+// CNPJ (cnpjIndex, ou "cnpj_1" enquanto a migração não o remove). O servidor
+// identifica o índice na mensagem: "E11000 ... index: cnpj_ativo dup key: ...".
+func duplicateCNPJ(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "index: "+cnpjIndex+" ") || strings.Contains(msg, "index: cnpj_1 ")
+}
@@ -0,0 +1,43 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestMongoErrorDuplicateKey(t *testing.T) {
+	dup := func(index string) error {
+		return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+			Code:    11000,
+			Message: "E11000 duplicate key error collection: matriz.empresas index: " + index + " dup key: { x: 1 }",
+		}}}
+	}
+	for _, index := range []string{"cnpj_ativo", "cnpj_1"} {
+		if err := mongoError(dup(index)); !errors.Is(err, ErrDuplicateCNPJ) {
+			t.Errorf("índice %s: %v, want ErrDuplicateCNPJ", index, err)
+		}
+	}
+	// chaves duplicadas do histórico ou do outbox não são CNPJ duplicado
+	for _, index := range []string{"empresa_id_1_version_1", "_id_"} {
+		if err := mongoError(dup(index)); errors.Is(err, ErrDuplicateCNPJ) || err == nil {
+			t.Errorf("índice %s: %v, want erro interno", index, err)
+		}
+	}
+}
+
+func TestEmpresaID(t *testing.T) {
+	obj := primitive.NewObjectID()
+	if id, err := empresaID(obj.Hex()); err != nil || id != obj {
+		t.Errorf("empresaID(%s) = %v, %v, want ObjectID", obj.Hex(), id, err)
+	}
+	// _id string gravado por versões anteriores
+	if id, err := empresaID("legado-1"); err != nil || id != "legado-1" {
+		t.Errorf("empresaID(legado-1) = %v, %v, want string", id, err)
+	}
+	if _, err := empresaID(""); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("empresaID vazio = %v, want ErrInvalidID", err)
+	}
+}
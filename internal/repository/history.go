@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"time"
+
+	"matriz/internal/models"
+)
+
+// Operações registradas no histórico.
+const (
+	OpCreate  = "create"
+	OpUpdate  = "update"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+	OpPurge   = "purge"
+)
+
+// HistoryEntry é a entrada imutável do histórico gravada a cada alteração de
+// uma empresa: quem fez (By), quando (At), a operação, os campos alterados e
+// o estado completo resultante (Snapshot) na versão Version. O expurgo é a
+// exceção: apaga os dados da empresa também das entradas anteriores (veja
+// redact).
+type HistoryEntry struct {
+	EmpresaID string        `json:"empresa_id" bson:"empresa_id"`
+	Version   int64         `json:"version" bson:"version"`
+	Op        string        `json:"op" bson:"op"`
+	At        time.Time     `json:"at" bson:"at"`
+	By        string        `json:"by,omitempty" bson:"by"`
+	Changes   []FieldChange `json:"changes" bson:"changes"`
+	// Snapshot só é preenchido por HistoryVersion.
+	Snapshot *models.Empresa `json:"snapshot,omitempty" bson:"snapshot"`
+}
+
+// FieldChange é o valor de um campo antes e depois de uma alteração; Before
+// é nil na criação.
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
+
+// historyEntry monta a entrada da operação op que levou a empresa de before
+// (nil na criação) a after.
+func historyEntry(op string, before *models.Empresa, after models.Empresa) HistoryEntry {
+	after.CNPJFormatado, after.CotaLegalPCD, after.StatusPCD, after.DeficitPCD = "", 0, "", 0
+	return HistoryEntry{
+		EmpresaID: after.ID,
+		Version:   after.Version,
+		Op:        op,
+		At:        after.UpdatedAt,
+		By:        after.UpdatedBy,
+		Changes:   diff(before, &after),
+		Snapshot:  &after,
+	}
+}
+
+// purgeEntry monta a entrada do expurgo de e, feito por by em at. Não há
+// snapshot nem valores: a entrada só registra quem expurgou a empresa,
+// quando e em que versão.
+func purgeEntry(e models.Empresa, at time.Time, by string) HistoryEntry {
+	return HistoryEntry{
+		EmpresaID: e.ID,
+		Version:   e.Version + 1,
+		Op:        OpPurge,
+		At:        at,
+		By:        by,
+		Changes:   []FieldChange{},
+	}
+}
+
+// redact apaga de entry os dados da empresa, o snapshot e os valores das
+// alterações, mantendo a operação, a autoria e os nomes dos campos
+// alterados. É aplicado ao histórico e ao outbox de empresas expurgadas,
+// para que o expurgo não deixe cópias dos dados.
+func redact(entry HistoryEntry) HistoryEntry {
+	changes := make([]FieldChange, len(entry.Changes))
+	for i, c := range entry.Changes {
+		changes[i] = FieldChange{Field: c.Field}
+	}
+	entry.Changes, entry.Snapshot = changes, nil
+	return entry
+}
+
+// diff lista os campos de dados (e deleted_at) que mudaram de before para
+// after. Versão e auditoria ficam de fora: já estão na própria entrada.
+func diff(before, after *models.Empresa) []FieldChange {
+	var b models.Empresa
+	if before != nil {
+		b = *before
+	}
+	changes := []FieldChange{}
+	for _, f := range []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"cnpj", b.CNPJ, after.CNPJ},
+		{"nome_fantasia", b.NomeFantasia, after.NomeFantasia},
+		{"razao_social", b.RazaoSocial, after.RazaoSocial},
+		{"endereco", b.Endereco, after.Endereco},
+		{"num_funcionarios", b.NumFuncionarios, after.NumFuncionarios},
+		{"num_min_pcd", b.NumMinPCD, after.NumMinPCD},
+		{"num_pcd_contratados", b.NumPCDContratados, after.NumPCDContratados},
+		{"deleted_at", timeOrNil(b.DeletedAt), timeOrNil(after.DeletedAt)},
+	} {
+		if f.old == f.new {
+			continue
+		}
+		if before == nil {
+			f.old = nil
+		}
+		changes = append(changes, FieldChange{Field: f.name, Before: f.old, After: f.new})
+	}
+	return changes
+}
+
+// timeOrNil desreferencia t, mantendo nil para ausente, para que datas iguais
+// em ponteiros distintos sejam comparadas pelo valor.
+func timeOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
@@ -0,0 +1,531 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"matriz/internal/auth"
+	"matriz/internal/models"
+	"matriz/internal/validation"
+)
+
+// MemoryEmpresaRepo é um EmpresaStore em memória, seguro para uso
+// concorrente, para testes e desenvolvimento local. Segue a mesma semântica
+// de EmpresaRepo: CNPJ normalizado e único, IDs gerados no formato ObjectID
+// e os mesmos erros do pacote.
+type MemoryEmpresaRepo struct {
+	mu     sync.RWMutex
+	items  map[string]models.Empresa
+	byCNPJ map[string]string // cnpj normalizado -> id, só de empresas não excluídas
+	// history guarda as entradas de cada empresa em ordem de versão
+	history map[string][]HistoryEntry
+	// outbox guarda os eventos em ordem de gravação, se habilitado
+	outbox        []OutboxEntry
+	outboxEnabled bool
+}
+
+// NewMemoryEmpresaRepo cria um repositório em memória vazio.
+func NewMemoryEmpresaRepo(opts ...Option) *MemoryEmpresaRepo {
+	return &MemoryEmpresaRepo{
+		items:         make(map[string]models.Empresa),
+		byCNPJ:        make(map[string]string),
+		history:       make(map[string][]HistoryEntry),
+		outboxEnabled: newStoreOptions(opts).outbox,
+	}
+}
+
+func (r *MemoryEmpresaRepo) Create(ctx context.Context, e *models.Empresa) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.CNPJ = validation.NormalizeCNPJ(e.CNPJ)
+	if _, ok := r.byCNPJ[e.CNPJ]; ok {
+		return "", ErrDuplicateCNPJ
+	}
+	id := primitive.NewObjectID().Hex()
+	stampCreate(ctx, e)
+	item := *e
+	item.ID = id
+	r.items[id] = item
+	r.byCNPJ[item.CNPJ] = id
+	r.record(OpCreate, nil, item)
+	return id, nil
+}
+
+func (r *MemoryEmpresaRepo) Get(ctx context.Context, id string) (*models.Empresa, error) {
+	if _, err := empresaID(id); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	item, ok := r.items[id]
+	if !ok || item.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	return &item, nil
+}
+
+func (r *MemoryEmpresaRepo) GetByCNPJ(ctx context.Context, cnpj string) (*models.Empresa, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	id, ok := r.byCNPJ[validation.NormalizeCNPJ(cnpj)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	item := r.items[id]
+	return &item, nil
+}
+
+func (r *MemoryEmpresaRepo) List(ctx context.Context, q ListQuery) (*ListResult, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
+	var after *cursor
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = &c
+	}
+
+	r.mu.RLock()
+	matched := make([]models.Empresa, 0, len(r.items))
+	for _, e := range r.items {
+		if memoryMatches(&e, q) {
+			matched = append(matched, e)
+		}
+	}
+	r.mu.RUnlock()
+
+	less := func(a, b *models.Empresa) bool {
+		c := compareValues(sortValue(a, q.Sort), sortValue(b, q.Sort))
+		if c == 0 {
+			c = strings.Compare(a.ID, b.ID)
+		}
+		if q.Desc {
+			return c > 0
+		}
+		return c < 0
+	}
+	sort.Slice(matched, func(i, j int) bool { return less(&matched[i], &matched[j]) })
+
+	total := int64(len(matched))
+	if after != nil {
+		start := sort.Search(len(matched), func(i int) bool {
+			return afterCursor(&matched[i], q, *after)
+		})
+		matched = matched[start:]
+	}
+	if len(matched) > q.Limit+1 {
+		matched = matched[:q.Limit+1]
+	}
+	return page(matched, q, total)
+}
+
+func (r *MemoryEmpresaRepo) Search(ctx context.Context, text string, limit int) ([]SearchHit, error) {
+	limit = searchLimit(limit)
+	termos := make(map[string]bool)
+	for _, t := range searchTokens(text) {
+		termos[t] = true
+	}
+
+	r.mu.RLock()
+	hits := make([]SearchHit, 0)
+	for _, e := range r.items {
+		if e.DeletedAt != nil {
+			continue
+		}
+		score := 0.0
+		for _, f := range []struct {
+			valor string
+			peso  float64
+		}{
+			{e.NomeFantasia, 10},
+			{e.RazaoSocial, 5},
+			{e.Endereco, 1},
+		} {
+			for _, t := range searchTokens(f.valor) {
+				if termos[t] {
+					score += f.peso
+				}
+			}
+		}
+		if score > 0 {
+			hits = append(hits, SearchHit{Empresa: e, Score: score})
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+	return hits, nil
+}
+
+func (r *MemoryEmpresaRepo) Update(ctx context.Context, id string, e *models.Empresa, expectedVersion int64) error {
+	if _, err := empresaID(id); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e.CNPJ = validation.NormalizeCNPJ(e.CNPJ)
+	old, err := r.current(id, expectedVersion)
+	if err != nil {
+		return err
+	}
+	if owner, ok := r.byCNPJ[e.CNPJ]; ok && owner != id {
+		return ErrDuplicateCNPJ
+	}
+	stampUpdate(ctx, e)
+	e.Version, e.CreatedAt, e.CreatedBy = old.Version+1, old.CreatedAt, old.CreatedBy
+	item := *e
+	item.ID = id
+	r.replace(OpUpdate, old, item)
+	return nil
+}
+
+func (r *MemoryEmpresaRepo) Patch(ctx context.Context, id string, ch Changes, expectedVersion int64) (int64, error) {
+	if _, err := empresaID(id); err != nil {
+		return 0, err
+	}
+	ch, err := ch.normalize()
+	if err != nil {
+		return 0, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, err := r.current(id, expectedVersion)
+	if err != nil {
+		return 0, err
+	}
+	if ch.Empty() {
+		return old.Version, nil
+	}
+	// aplica as alterações sobre a forma bson do documento, como o Mongo faria
+	raw, err := bson.Marshal(old)
+	if err != nil {
+		return 0, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return 0, err
+	}
+	ch.apply(doc)
+	if raw, err = bson.Marshal(doc); err != nil {
+		return 0, err
+	}
+	var item models.Empresa
+	if err := bson.Unmarshal(raw, &item); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidChange, err)
+	}
+	if owner, ok := r.byCNPJ[item.CNPJ]; ok && owner != id {
+		return 0, ErrDuplicateCNPJ
+	}
+	item.ID = id
+	item.Version = old.Version + 1
+	stampUpdate(ctx, &item)
+	r.replace(OpUpdate, old, item)
+	return item.Version, nil
+}
+
+func (r *MemoryEmpresaRepo) Delete(ctx context.Context, id string, expectedVersion int64) error {
+	if _, err := empresaID(id); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, err := r.current(id, expectedVersion)
+	if err != nil {
+		return err
+	}
+	item := old
+	item.Version = old.Version + 1
+	stampUpdate(ctx, &item)
+	deletedAt := item.UpdatedAt
+	item.DeletedAt = &deletedAt
+	r.replace(OpDelete, old, item)
+	return nil
+}
+
+func (r *MemoryEmpresaRepo) Restore(ctx context.Context, id string) (*models.Empresa, error) {
+	if _, err := empresaID(id); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.items[id]
+	if !ok || old.DeletedAt == nil {
+		return nil, ErrNotFound
+	}
+	if _, ok := r.byCNPJ[old.CNPJ]; ok {
+		return nil, ErrDuplicateCNPJ
+	}
+	item := old
+	item.Version = old.Version + 1
+	item.DeletedAt = nil
+	stampUpdate(ctx, &item)
+	r.replace(OpRestore, old, item)
+	return &item, nil
+}
+
+func (r *MemoryEmpresaRepo) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	at, by := now(), auth.PrincipalFrom(ctx)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	purged := make([]string, 0)
+	for id, e := range r.items {
+		if e.DeletedAt == nil || !e.DeletedAt.Before(before) {
+			continue
+		}
+		delete(r.items, id)
+		for i, entry := range r.history[id] {
+			r.history[id][i] = redact(entry)
+		}
+		for i := range r.outbox {
+			if r.outbox[i].Event.EmpresaID == id {
+				r.outbox[i].Event = redact(r.outbox[i].Event)
+			}
+		}
+		r.append(purgeEntry(e, at, by))
+		purged = append(purged, id)
+	}
+	sort.Strings(purged)
+	return purged, nil
+}
+
+func (r *MemoryEmpresaRepo) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	if _, err := empresaID(id); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]HistoryEntry, 0, len(r.history[id]))
+	for _, entry := range r.history[id] {
+		entry.Snapshot = nil
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r *MemoryEmpresaRepo) HistoryVersion(ctx context.Context, id string, version int64) (*HistoryEntry, error) {
+	if _, err := empresaID(id); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, entry := range r.history[id] {
+		if entry.Version == version {
+			if entry.Snapshot != nil {
+				snapshot := *entry.Snapshot
+				entry.Snapshot = &snapshot
+			}
+			return &entry, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// current devolve a empresa id se ela existir, não estiver excluída e
+// estiver na versão esperada. Deve ser chamado com r.mu travado.
+func (r *MemoryEmpresaRepo) current(id string, expectedVersion int64) (models.Empresa, error) {
+	old, ok := r.items[id]
+	if !ok || old.DeletedAt != nil {
+		return old, ErrNotFound
+	}
+	if expectedVersion != AnyVersion && old.Version != expectedVersion {
+		return old, ErrVersionConflict
+	}
+	return old, nil
+}
+
+// replace troca old por item como a operação op, atualizando o índice de
+// CNPJ, que só contém empresas não excluídas, e o histórico. Deve ser
+// chamado com r.mu travado.
+func (r *MemoryEmpresaRepo) replace(op string, old, item models.Empresa) {
+	if r.byCNPJ[old.CNPJ] == old.ID {
+		delete(r.byCNPJ, old.CNPJ)
+	}
+	r.items[item.ID] = item
+	if item.DeletedAt == nil {
+		r.byCNPJ[item.CNPJ] = item.ID
+	}
+	r.record(op, &old, item)
+}
+
+// record acrescenta ao histórico, e ao outbox se habilitado, a operação op
+// que levou a empresa de before a after. Deve ser chamado com r.mu travado.
+func (r *MemoryEmpresaRepo) record(op string, before *models.Empresa, after models.Empresa) {
+	r.append(historyEntry(op, before, after))
+}
+
+// append acrescenta entry ao histórico e, se habilitado, ao outbox. Deve
+// ser chamado com r.mu travado.
+func (r *MemoryEmpresaRepo) append(entry HistoryEntry) {
+	r.history[entry.EmpresaID] = append(r.history[entry.EmpresaID], entry)
+	if r.outboxEnabled {
+		r.outbox = append(r.outbox, OutboxEntry{ID: primitive.NewObjectID().Hex(), Event: entry})
+	}
+}
+
+func (r *MemoryEmpresaRepo) PendingEvents(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entries := make([]OutboxEntry, 0)
+	for _, entry := range r.outbox {
+		if len(entries) == limit {
+			break
+		}
+		if entry.DeliveredAt == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+func (r *MemoryEmpresaRepo) MarkDelivered(ctx context.Context, id string) error {
+	return r.updateOutbox(id, func(entry *OutboxEntry) {
+		at := now()
+		entry.DeliveredAt = &at
+	})
+}
+
+func (r *MemoryEmpresaRepo) MarkFailed(ctx context.Context, id string, cause error) error {
+	return r.updateOutbox(id, func(entry *OutboxEntry) {
+		entry.Attempts++
+		entry.LastError = cause.Error()
+	})
+}
+
+// updateOutbox aplica fn à entrada id do outbox.
+func (r *MemoryEmpresaRepo) updateOutbox(id string, fn func(*OutboxEntry)) error {
+	if _, err := parseID(id); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.outbox {
+		if r.outbox[i].ID == id {
+			fn(&r.outbox[i])
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// memoryMatches aplica os filtros de q, com a mesma semântica de listFilter.
+func memoryMatches(e *models.Empresa, q ListQuery) bool {
+	if e.DeletedAt != nil {
+		return false
+	}
+	if q.NomeFantasiaPrefix != "" && !strings.HasPrefix(e.NomeFantasia, q.NomeFantasiaPrefix) {
+		return false
+	}
+	if q.RazaoSocialContains != "" &&
+		!strings.Contains(strings.ToLower(e.RazaoSocial), strings.ToLower(q.RazaoSocialContains)) {
+		return false
+	}
+	if q.MinFuncionarios != nil && e.NumFuncionarios < *q.MinFuncionarios {
+		return false
+	}
+	if q.MaxFuncionarios != nil && e.NumFuncionarios > *q.MaxFuncionarios {
+		return false
+	}
+	return true
+}
+
+// afterCursor indica se e vem depois da posição c na ordenação de q.
+func afterCursor(e *models.Empresa, q ListQuery, c cursor) bool {
+	cmp := 0
+	if q.Sort != "_id" {
+		cmp = compareValues(sortValue(e, q.Sort), c.Value)
+	}
+	if cmp == 0 {
+		cmp = strings.Compare(e.ID, c.ID)
+	}
+	if q.Desc {
+		return cmp < 0
+	}
+	return cmp > 0
+}
+
+// compareValues compara valores de ordenação (texto ou inteiros, estes
+// possivelmente decodificados de um cursor como int32/int64).
+func compareValues(a, b interface{}) int {
+	if as, ok := a.(string); ok {
+		bs, _ := b.(string)
+		return strings.Compare(as, bs)
+	}
+	ai, bi := toInt64(a), toInt64(b)
+	switch {
+	case ai < bi:
+		return -1
+	case ai > bi:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// toInt64 converte os tipos inteiros usados em compareValues.
+func toInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	default:
+		return 0
+	}
+}
+
+// stopwords ignoradas na busca, como no índice de texto em português.
+var stopwords = map[string]bool{
+	"a": true, "o": true, "as": true, "os": true, "e": true, "de": true, "da": true,
+	"do": true, "das": true, "dos": true, "em": true, "na": true, "no": true,
+	"nas": true, "nos": true, "um": true, "uma": true, "para": true, "por": true,
+}
+
+var semAcento = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+// searchTokens divide o texto em termos de busca sem acentos e em minúsculas,
+// descartando stopwords e o plural simples ("s" final), aproximando o
+// comportamento do índice de texto do MongoDB.
+func searchTokens(s string) []string {
+	words := strings.FieldsFunc(semAcento.Replace(strings.ToLower(s)), func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsDigit(c)
+	})
+	tokens := words[:0]
+	for _, w := range words {
+		if stopwords[w] {
+			continue
+		}
+		if len(w) > 3 && strings.HasSuffix(w, "s") {
+			w = w[:len(w)-1]
+		}
+		tokens = append(tokens, w)
+	}
+	return tokens
+}
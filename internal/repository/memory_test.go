@@ -0,0 +1,14 @@
+package repository_test
+
+import (
+	"testing"
+
+	"matriz/internal/repository"
+	"matriz/internal/repository/storetest"
+)
+
+func TestMemoryEmpresaRepo(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.EmpresaStore {
+		return repository.NewMemoryEmpresaRepo(repository.WithOutbox())
+	})
+}
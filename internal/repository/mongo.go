@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"regexp"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -10,66 +11,329 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
+	"matriz/internal/auth"
 	"matriz/internal/models"
+	"matriz/internal/validation"
 )
 
+// EmpresaStore é o repositório de empresas. As implementações devolvem os
+// erros do pacote (ErrNotFound, ErrDuplicateCNPJ, ErrInvalidID,
+// ErrUnavailable, ErrInvalidQuery, ErrInvalidChange), identificáveis com
+// errors.Is. Toda alteração (Create, Update, Patch, Delete e Restore) grava
+// uma HistoryEntry com a nova versão.
 type EmpresaStore interface {
 	Create(ctx context.Context, e *models.Empresa) (string, error)
 	Get(ctx context.Context, id string) (*models.Empresa, error)
 	GetByCNPJ(ctx context.Context, cnpj string) (*models.Empresa, error)
-	List(ctx context.Context) ([]models.Empresa, error)
-	Update(ctx context.Context, id string, e *models.Empresa) error
-	Delete(ctx context.Context, id string) error
+	List(ctx context.Context, q ListQuery) (*ListResult, error)
+	Search(ctx context.Context, text string, limit int) ([]SearchHit, error)
+	// Update, Patch e Delete são condicionais: se expectedVersion não for
+	// AnyVersion e a versão gravada for outra, devolvem ErrVersionConflict.
+	// Devolvem ErrNotFound quando o documento não existe ou foi excluído.
+	// Update grava a nova versão em e.Version.
+	Update(ctx context.Context, id string, e *models.Empresa, expectedVersion int64) error
+	// Patch altera apenas os campos listados em ch e devolve a nova versão.
+	Patch(ctx context.Context, id string, ch Changes, expectedVersion int64) (int64, error)
+	// Delete faz a exclusão lógica: a empresa some das consultas, e seu CNPJ
+	// pode ser reutilizado, até ser restaurada com Restore ou removida de vez
+	// com Purge.
+	Delete(ctx context.Context, id string, expectedVersion int64) error
+	Restore(ctx context.Context, id string) (*models.Empresa, error)
+	// Purge remove definitivamente as empresas excluídas antes de before e
+	// devolve os IDs removidos. O histórico delas é mantido, sem os dados
+	// (veja redact), e ganha uma entrada OpPurge com o principal de ctx.
+	Purge(ctx context.Context, before time.Time) ([]string, error)
+	// History devolve o histórico da empresa id em ordem de versão, sem os
+	// snapshots; a lista é vazia se não houver registros.
+	History(ctx context.Context, id string) ([]HistoryEntry, error)
+	// HistoryVersion devolve a entrada, com o snapshot, que gerou a versão
+	// version da empresa id, ou ErrNotFound.
+	HistoryVersion(ctx context.Context, id string, version int64) (*HistoryEntry, error)
 }
 
+// AnyVersion desativa a verificação de versão em Update, Patch e Delete.
+// Documentos recebem a versão 1 em Create, então 0 nunca é uma versão gravada.
+const AnyVersion int64 = 0
+
+// now devolve o instante gravado em created_at/updated_at, com a precisão
+// de milissegundos do datetime BSON.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// stampCreate preenche a versão inicial e os campos de auditoria de uma
+// nova empresa, com o principal de ctx, descartando valores do cliente.
+func stampCreate(ctx context.Context, e *models.Empresa) {
+	at, by := now(), auth.PrincipalFrom(ctx)
+	e.Version = 1
+	e.CreatedAt, e.CreatedBy = at, by
+	e.UpdatedAt, e.UpdatedBy = at, by
+}
+
+// stampUpdate preenche os campos de auditoria de uma alteração.
+func stampUpdate(ctx context.Context, e *models.Empresa) {
+	e.UpdatedAt, e.UpdatedBy = now(), auth.PrincipalFrom(ctx)
+}
+
+// EmpresaRepo é o EmpresaStore sobre MongoDB. O histórico de cada coleção
+// fica na coleção de mesmo nome com o sufixo HistorySuffix e, com
+// WithOutbox, os eventos na coleção com o sufixo OutboxSuffix. A versão do
+// formato dos documentos (veja migrate) fica na coleção com o sufixo
+// MigrationsSuffix.
 type EmpresaRepo struct {
-	col *mongo.Collection
+	col    *mongo.Collection
+	hist   *mongo.Collection
+	outbox *mongo.Collection // nil sem WithOutbox
+	// transactions indica se o deployment aceita transações (veja tx)
+	transactions bool
 }
 
-func NewMongoEmpresaRepo(client *mongo.Client, db, collection string) (*EmpresaRepo, error) {
+// Sufixos das coleções auxiliares (ex.: empresas_historico e empresas_outbox).
+const (
+	HistorySuffix    = "_historico"
+	OutboxSuffix     = "_outbox"
+	MigrationsSuffix = "_migracoes"
+)
+
+// deliveredRetention é por quanto tempo eventos já entregues permanecem no
+// outbox antes de serem removidos pelo índice TTL.
+const deliveredRetention = 7 * 24 * time.Hour
+
+func NewMongoEmpresaRepo(client *mongo.Client, db, collection string, opts ...Option) (*EmpresaRepo, error) {
+	o := newStoreOptions(opts)
 	col := client.Database(db).Collection(collection)
-	// ensure unique index on cnpj
-	_, err := col.Indexes().CreateOne(context.Background(), mongo.IndexModel{
-		Keys:    bson.D{{Key: "cnpj", Value: 1}},
+	repo := &EmpresaRepo{col: col, hist: client.Database(db).Collection(collection + HistorySuffix)}
+	meta := client.Database(db).Collection(collection + MigrationsSuffix)
+	if err := migrate(context.Background(), col, meta); err != nil {
+		return repo, err
+	}
+	txn, err := supportsTransactions(context.Background(), client)
+	if err != nil {
+		return repo, err
+	}
+	if o.outbox && !txn {
+		return repo, errors.New("repository: o outbox exige transações, disponíveis só em replica set ou mongos")
+	}
+	repo.transactions = txn
+	// one entry per company version; also backs History's sort
+	_, err = repo.hist.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "empresa_id", Value: 1}, {Key: "version", Value: 1}},
 		Options: options.Index().SetUnique(true),
 	})
-	return &EmpresaRepo{col: col}, err
+	if err != nil {
+		return repo, err
+	}
+	if o.outbox {
+		repo.outbox = client.Database(db).Collection(collection + OutboxSuffix)
+		_, err = repo.outbox.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+			// pending events (delivered_at null) in insertion order, for
+			// PendingEvents; _id indexes cannot be partial, so delivered_at leads
+			{
+				Keys:    bson.D{{Key: "delivered_at", Value: 1}, {Key: "_id", Value: 1}},
+				Options: options.Index().SetName("outbox_pendentes"),
+			},
+			// delivered events expire after deliveredRetention
+			{
+				Keys: bson.D{{Key: "delivered_at", Value: 1}},
+				Options: options.Index().
+					SetName("outbox_entregues").
+					SetExpireAfterSeconds(int32(deliveredRetention / time.Second)),
+			},
+		})
+		if err != nil {
+			return repo, err
+		}
+	}
+	_, err = col.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		// also created by migrate; repeated here in case it was dropped by hand
+		cnpjIndexModel(),
+		// soft-deleted companies, for Purge
+		{
+			Keys: bson.D{{Key: "deleted_at", Value: 1}},
+			Options: options.Index().
+				SetName("empresas_excluidas").
+				SetPartialFilterExpression(bson.M{"deleted_at": bson.M{"$type": "date"}}),
+		},
+		// indexes backing List sorting and filters (see SortFields); _id breaks ties
+		// for cursor pagination
+		{Keys: bson.D{{Key: "nome_fantasia", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "razao_social", Value: 1}, {Key: "_id", Value: 1}}},
+		{Keys: bson.D{{Key: "num_funcionarios", Value: 1}, {Key: "_id", Value: 1}}},
+		// text index for Search; portuguese stemming, case and diacritic insensitive
+		{
+			Keys: bson.D{
+				{Key: "nome_fantasia", Value: "text"},
+				{Key: "razao_social", Value: "text"},
+				{Key: "endereco", Value: "text"},
+			},
+			Options: options.Index().
+				SetName("empresas_texto").
+				SetDefaultLanguage("portuguese").
+				SetWeights(bson.D{
+					{Key: "nome_fantasia", Value: 10},
+					{Key: "razao_social", Value: 5},
+					{Key: "endereco", Value: 1},
+				}),
+		},
+	})
+	return repo, err
+}
+
+// cnpjIndex é o índice único parcial de cnpj, que substitui o índice
+// único total "cnpj_1" das versões anteriores.
+const cnpjIndex = "cnpj_ativo"
+
+// cnpjIndexModel descreve o índice cnpjIndex.
+func cnpjIndexModel() mongo.IndexModel {
+	// unique cnpj among active companies; values are stored normalized (see
+	// validation.NormalizeCNPJ) so punctuation and letter case never produce
+	// distinct keys, and soft-deleted companies do not block their CNPJ
+	return mongo.IndexModel{
+		Keys: bson.D{{Key: "cnpj", Value: 1}},
+		Options: options.Index().
+			SetName(cnpjIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(active(bson.M{})),
+	}
+}
+
+// schemaVersion é a versão atual do formato dos documentos de empresa.
+// Incremente-a ao acrescentar passos a migrate.
+const schemaVersion = 2
+
+// supportsTransactions informa se o deployment de client aceita transações:
+// membros de replica set e mongos aceitam, servidores standalone não.
+func supportsTransactions(ctx context.Context, client *mongo.Client) (bool, error) {
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "isMaster", Value: 1}}).Decode(&hello)
+	if err != nil {
+		return false, err
+	}
+	return hello.SetName != "" || hello.Msg == "isdbgrid", nil
+}
+
+// migrate completa documentos gravados por versões anteriores do serviço e
+// troca o índice único total de cnpj, incompatível com exclusão lógica, pelo
+// parcial. O parcial é criado antes de o total ser removido, e a versão só
+// é gravada no fim, para que a coleção nunca fique sem unicidade de CNPJ e
+// uma migração interrompida seja retomada na próxima subida. Os passos
+// percorrem a coleção inteira, então só rodam se a versão gravada em meta
+// for anterior a schemaVersion; são idempotentes, e instâncias subindo ao
+// mesmo tempo podem repeti-los sem problema.
+func migrate(ctx context.Context, col, meta *mongo.Collection) error {
+	var mark struct {
+		Version int `bson:"version"`
+	}
+	err := meta.FindOne(ctx, bson.M{"_id": "schema"}).Decode(&mark)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if mark.Version >= schemaVersion {
+		return nil
+	}
+
+	// documents written before versioning start at version 1
+	_, err = col.UpdateMany(ctx,
+		bson.M{"version": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"version": int64(1)}})
+	if err != nil {
+		return err
+	}
+	// and take created_at/updated_at from the creation time embedded in the
+	// ObjectID; $toDate fails on any other _id type, so those are skipped
+	for _, field := range []string{"created_at", "updated_at"} {
+		_, err = col.UpdateMany(ctx,
+			bson.M{field: bson.M{"$exists": false}, "_id": bson.M{"$type": "objectId"}},
+			bson.A{bson.M{"$set": bson.M{field: bson.M{"$toDate": "$_id"}}}})
+		if err != nil {
+			return err
+		}
+	}
+	// active documents carry an explicit deleted_at: null (see active)
+	_, err = col.UpdateMany(ctx,
+		bson.M{"deleted_at": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"deleted_at": nil}})
+	if err != nil {
+		return err
+	}
+	// PATCH used to $unset emptied fields; keyset cursors and range filters
+	// expect every field present, so those get their zero value back (cnpj
+	// is required and was never unset, and an empty one would collide in
+	// the unique index)
+	for field, zero := range map[string]interface{}{
+		"nome_fantasia": "", "razao_social": "", "endereco": "",
+		"num_funcionarios": 0, "num_min_pcd": 0, "num_pcd_contratados": 0,
+	} {
+		_, err = col.UpdateMany(ctx,
+			bson.M{field: bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{field: zero}})
+		if err != nil {
+			return err
+		}
+	}
+	if _, err = col.Indexes().CreateOne(ctx, cnpjIndexModel()); err != nil {
+		return err
+	}
+	_, err = col.Indexes().DropOne(ctx, "cnpj_1")
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) && (cmdErr.Name == "IndexNotFound" || cmdErr.Name == "NamespaceNotFound") {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = meta.UpdateOne(ctx, bson.M{"_id": "schema"},
+		bson.M{"$set": bson.M{"version": schemaVersion, "migrated_at": now()}},
+		options.Update().SetUpsert(true))
+	return err
+}
+
+// active restringe filter às empresas não excluídas, que gravam deleted_at
+// como null. $type é usado em vez de igualdade com null porque é aceito em
+// índices parciais, e assim as consultas coincidem com o filtro do índice
+// único de cnpj.
+func active(filter bson.M) bson.M {
+	filter["deleted_at"] = bson.M{"$type": "null"}
+	return filter
 }
 
 func (r *EmpresaRepo) Create(ctx context.Context, e *models.Empresa) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	res, err := r.col.InsertOne(ctx, e)
-	if err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			return "", errors.New("cnpj já cadastrado")
+	e.CNPJ = validation.NormalizeCNPJ(e.CNPJ)
+	// IDs, versions and audit fields are always set by the store
+	stampCreate(ctx, e)
+	var doc models.Empresa
+	err := r.tx(ctx, func(ctx context.Context) error {
+		doc = *e
+		doc.ID = ""
+		res, err := r.col.InsertOne(ctx, doc)
+		if err != nil {
+			return err
 		}
-		return "", err
-	}
-	id := res.InsertedID
-	switch v := id.(type) {
-	case primitive.ObjectID:
-		return v.Hex(), nil
-	case string:
-		return v, nil
-	default:
-		return "", nil
+		doc.ID = res.InsertedID.(primitive.ObjectID).Hex()
+		return r.record(ctx, OpCreate, nil, doc)
+	})
+	if err != nil {
+		return "", mongoError(err)
 	}
+	return doc.ID, nil
 }
 
 func (r *EmpresaRepo) Get(ctx context.Context, id string) (*models.Empresa, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	var e models.Empresa
-	objID, err := primitive.ObjectIDFromHex(id)
-	if err == nil {
-		err = r.col.FindOne(ctx, bson.M{"_id": objID}).Decode(&e)
-	} else {
-		err = r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&e)
-	}
+	docID, err := empresaID(id)
 	if err != nil {
 		return nil, err
 	}
+	var e models.Empresa
+	if err := r.col.FindOne(ctx, active(bson.M{"_id": docID})).Decode(&e); err != nil {
+		return nil, mongoError(err)
+	}
 	return &e, nil
 }
 
@@ -77,58 +341,618 @@ func (r *EmpresaRepo) GetByCNPJ(ctx context.Context, cnpj string) (*models.Empre
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 	var e models.Empresa
-	if err := r.col.FindOne(ctx, bson.M{"cnpj": cnpj}).Decode(&e); err != nil {
-		return nil, err
+	filter := active(bson.M{"cnpj": validation.NormalizeCNPJ(cnpj)})
+	if err := r.col.FindOne(ctx, filter).Decode(&e); err != nil {
+		return nil, mongoError(err)
 	}
 	return &e, nil
 }
 
-func (r *EmpresaRepo) List(ctx context.Context) ([]models.Empresa, error) {
+// List devolve uma página de empresas conforme q, usando paginação por
+// cursor (keyset) sobre o campo de ordenação e _id.
+func (r *EmpresaRepo) List(ctx context.Context, q ListQuery) (*ListResult, error) {
+	if err := q.normalize(); err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
-	cur, err := r.col.Find(ctx, bson.M{})
+
+	filter := listFilter(q)
+	total, err := r.col.CountDocuments(ctx, filter)
 	if err != nil {
-		return nil, err
+		return nil, mongoError(err)
+	}
+
+	dir := 1
+	if q.Desc {
+		dir = -1
+	}
+	sort := bson.D{{Key: q.Sort, Value: dir}}
+	if q.Sort != "_id" {
+		sort = append(sort, bson.E{Key: "_id", Value: dir})
+	}
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		filter = bson.M{"$and": bson.A{filter, cursorFilter(q, c)}}
+	}
+
+	opts := options.Find().SetSort(sort).SetLimit(int64(q.Limit) + 1)
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, mongoError(err)
 	}
 	defer cur.Close(ctx)
-	var items []models.Empresa
-	for cur.Next(ctx) {
-		var e models.Empresa
-		if err := cur.Decode(&e); err != nil {
+	items := make([]models.Empresa, 0, q.Limit)
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, mongoError(err)
+	}
+	return page(items, q, total)
+}
+
+// Search faz busca textual em nome_fantasia, razao_social e endereco usando o
+// índice de texto, sem diferenciar maiúsculas nem acentos, e devolve até
+// limit resultados ordenados por relevância.
+func (r *EmpresaRepo) Search(ctx context.Context, text string, limit int) ([]SearchHit, error) {
+	limit = searchLimit(limit)
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	score := bson.M{"$meta": "textScore"}
+	opts := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.M{"score": score}).
+		SetLimit(int64(limit))
+	filter := active(bson.M{"$text": bson.M{"$search": text, "$language": "portuguese"}})
+	cur, err := r.col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	defer cur.Close(ctx)
+	hits := make([]SearchHit, 0)
+	if err := cur.All(ctx, &hits); err != nil {
+		return nil, mongoError(err)
+	}
+	return hits, nil
+}
+
+// page monta o ListResult a partir de até q.Limit+1 itens já ordenados;
+// o item excedente indica que existe uma próxima página.
+func page(items []models.Empresa, q ListQuery, total int64) (*ListResult, error) {
+	res := &ListResult{Items: items, Total: total}
+	if len(items) > q.Limit {
+		res.Items = items[:q.Limit]
+		last := &res.Items[q.Limit-1]
+		next, err := encodeCursor(cursor{Value: sortValue(last, q.Sort), ID: last.ID})
+		if err != nil {
 			return nil, err
 		}
-		items = append(items, e)
+		res.NextCursor = next
+	}
+	return res, nil
+}
+
+// listFilter traduz os filtros de q para uma consulta Mongo sobre as
+// empresas não excluídas.
+func listFilter(q ListQuery) bson.M {
+	filter := active(bson.M{})
+	if q.NomeFantasiaPrefix != "" {
+		filter["nome_fantasia"] = bson.M{"$regex": "^" + regexp.QuoteMeta(q.NomeFantasiaPrefix)}
+	}
+	if q.RazaoSocialContains != "" {
+		filter["razao_social"] = bson.M{"$regex": regexp.QuoteMeta(q.RazaoSocialContains), "$options": "i"}
+	}
+	if q.MinFuncionarios != nil || q.MaxFuncionarios != nil {
+		rng := bson.M{}
+		if q.MinFuncionarios != nil {
+			rng["$gte"] = *q.MinFuncionarios
+		}
+		if q.MaxFuncionarios != nil {
+			rng["$lte"] = *q.MaxFuncionarios
+		}
+		filter["num_funcionarios"] = rng
+	}
+	return filter
+}
+
+// cursorFilter seleciona os documentos posteriores ao cursor na ordenação de q.
+func cursorFilter(q ListQuery, c cursor) bson.M {
+	op := "$gt"
+	if q.Desc {
+		op = "$lt"
 	}
-	return items, cur.Err()
+	id, _ := empresaID(c.ID) // validated by decodeCursor
+	after := idAfter(op, id)
+	if q.Sort == "_id" {
+		return after
+	}
+	after[q.Sort] = c.Value
+	return bson.M{"$or": bson.A{
+		bson.M{q.Sort: bson.M{op: c.Value}},
+		after,
+	}}
 }
 
-func (r *EmpresaRepo) Update(ctx context.Context, id string, e *models.Empresa) error {
+// idAfter seleciona os documentos cujo _id vem depois de id na direção op.
+// _id string, de versões anteriores do serviço, ordena antes de qualquer
+// ObjectID, mas $gt/$lt só comparam valores do mesmo tipo; o outro tipo
+// entra no filtro quando fica do lado seguinte da ordenação.
+func idAfter(op string, id interface{}) bson.M {
+	other := "string"
+	if _, ok := id.(string); ok {
+		other = "objectId"
+	}
+	if (op == "$gt") == (other == "string") {
+		// strings come first ascending and ObjectIDs come first descending,
+		// so nothing of the other type is left
+		return bson.M{"_id": bson.M{op: id}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{"_id": bson.M{op: id}},
+		bson.M{"_id": bson.M{"$type": other}},
+	}}
+}
+
+func (r *EmpresaRepo) Update(ctx context.Context, id string, e *models.Empresa, expectedVersion int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	docID, err := empresaID(id)
+	if err != nil {
+		return err
+	}
+	e.CNPJ = validation.NormalizeCNPJ(e.CNPJ)
+	stampUpdate(ctx, e)
+	set, err := setDoc(e)
+	if err != nil {
+		return err
+	}
+	cur, err := r.update(ctx, docID, OpUpdate, bson.M{"$set": set}, expectedVersion)
+	if err != nil {
+		return err
+	}
+	e.Version, e.CreatedAt, e.CreatedBy = cur.Version, cur.CreatedAt, cur.CreatedBy
+	return nil
+}
+
+// Patch traduz ch em $set/$unset dos campos afetados, sem tocar nos demais.
+func (r *EmpresaRepo) Patch(ctx context.Context, id string, ch Changes, expectedVersion int64) (int64, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	var filter interface{}
-	if obj, err := primitive.ObjectIDFromHex(id); err == nil {
-		filter = bson.M{"_id": obj}
-	} else {
-		filter = bson.M{"_id": id}
+	docID, err := empresaID(id)
+	if err != nil {
+		return 0, err
+	}
+	ch, err = ch.normalize()
+	if err != nil {
+		return 0, err
 	}
-	_, err := r.col.UpdateOne(ctx, filter, bson.M{"$set": e})
+	if ch.Empty() {
+		// nada a gravar: a versão permanece a mesma
+		var cur models.Empresa
+		err := r.col.FindOne(ctx, versionFilter(docID, expectedVersion),
+			options.FindOne().SetProjection(bson.M{"version": 1})).Decode(&cur)
+		if err != nil {
+			return 0, r.notMatched(ctx, docID, expectedVersion, err)
+		}
+		return cur.Version, nil
+	}
+	update := ch.update()
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	var stamp models.Empresa
+	stampUpdate(ctx, &stamp)
+	set["updated_at"], set["updated_by"] = stamp.UpdatedAt, stamp.UpdatedBy
+	cur, err := r.update(ctx, docID, OpUpdate, update, expectedVersion)
 	if err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			return errors.New("cnpj já cadastrado")
+		return 0, err
+	}
+	return cur.Version, nil
+}
+
+// update aplica update ao documento docID, se estiver na versão esperada,
+// como a operação op (veja write).
+func (r *EmpresaRepo) update(ctx context.Context, docID interface{}, op string, update bson.M, expectedVersion int64) (*models.Empresa, error) {
+	cur, err := r.write(ctx, versionFilter(docID, expectedVersion), op, update)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, r.notMatched(ctx, docID, expectedVersion, err)
+	}
+	return cur, err
+}
+
+// write aplica update ao documento que casa com filter, incrementando a
+// versão, registra a operação op no histórico e devolve o documento
+// resultante. Se nenhum documento casar, devolve mongo.ErrNoDocuments para
+// o chamador traduzir.
+func (r *EmpresaRepo) write(ctx context.Context, filter bson.M, op string, update bson.M) (*models.Empresa, error) {
+	update["$inc"] = bson.M{"version": int64(1)}
+	var after *models.Empresa
+	err := r.tx(ctx, func(ctx context.Context) error {
+		var before models.Empresa
+		err := r.col.FindOneAndUpdate(ctx, filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.Before)).Decode(&before)
+		if err != nil {
+			return err
+		}
+		if after, err = applyUpdate(before, update); err != nil {
+			return err
 		}
+		return r.record(ctx, op, &before, *after)
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return after, nil
+}
+
+// tx executa fn numa transação, para que a alteração, o histórico e, com
+// outbox, o evento sejam gravados juntos. Num servidor standalone, que não
+// aceita transações (e por isso não admite outbox), fn roda diretamente.
+// fn pode ser repetida em erros transitórios e deve devolver os erros do
+// driver sem tradução.
+func (r *EmpresaRepo) tx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if !r.transactions {
+		return fn(ctx)
+	}
+	sess, err := r.col.Database().Client().StartSession()
+	if err != nil {
+		return err
 	}
+	defer sess.EndSession(ctx)
+	_, err = sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
 	return err
 }
 
-func (r *EmpresaRepo) Delete(ctx context.Context, id string) error {
+// applyUpdate reproduz sobre before os $set, $unset e o incremento de
+// versão de update, obtendo o documento gravado sem relê-lo.
+func applyUpdate(before models.Empresa, update bson.M) (*models.Empresa, error) {
+	raw, err := bson.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return nil, err
+	}
+	set, _ := update["$set"].(bson.M)
+	unset, _ := update["$unset"].(bson.M)
+	for k, v := range set {
+		doc[k] = v
+	}
+	for k := range unset {
+		delete(doc, k)
+	}
+	if raw, err = bson.Marshal(doc); err != nil {
+		return nil, err
+	}
+	var after models.Empresa
+	if err := bson.Unmarshal(raw, &after); err != nil {
+		return nil, err
+	}
+	after.Version = before.Version + 1
+	return &after, nil
+}
+
+// record grava no histórico, e no outbox se habilitado, a operação op que
+// levou a empresa de before a after. Num servidor standalone não há
+// transação: a alteração já foi aplicada quando record falha, e o erro é
+// devolvido para que a falha de auditoria não passe despercebida.
+func (r *EmpresaRepo) record(ctx context.Context, op string, before *models.Empresa, after models.Empresa) error {
+	return r.append(ctx, historyEntry(op, before, after))
+}
+
+// append grava entry no histórico e, se habilitado, no outbox.
+func (r *EmpresaRepo) append(ctx context.Context, entry HistoryEntry) error {
+	if _, err := r.hist.InsertOne(ctx, entry); err != nil {
+		return err
+	}
+	if r.outbox == nil {
+		return nil
+	}
+	_, err := r.outbox.InsertOne(ctx, OutboxEntry{Event: entry})
+	return err
+}
+
+// Delete faz a exclusão lógica da empresa: grava deleted_at, que a tira de
+// Get, GetByCNPJ, List e Search e libera o CNPJ, até Restore ou Purge.
+func (r *EmpresaRepo) Delete(ctx context.Context, id string, expectedVersion int64) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	var filter interface{}
-	if obj, err := primitive.ObjectIDFromHex(id); err == nil {
-		filter = bson.M{"_id": obj}
-	} else {
-		filter = bson.M{"_id": id}
+	docID, err := empresaID(id)
+	if err != nil {
+		return err
 	}
-	_, err := r.col.DeleteOne(ctx, filter)
+	var stamp models.Empresa
+	stampUpdate(ctx, &stamp)
+	_, err = r.update(ctx, docID, OpDelete, bson.M{"$set": bson.M{
+		"deleted_at": stamp.UpdatedAt,
+		"updated_at": stamp.UpdatedAt,
+		"updated_by": stamp.UpdatedBy,
+	}}, expectedVersion)
 	return err
 }
+
+// Restore desfaz a exclusão lógica da empresa id e a devolve. Devolve
+// ErrNotFound se não houver empresa excluída com esse id e
+// ErrDuplicateCNPJ se o CNPJ foi cadastrado novamente nesse meio tempo.
+func (r *EmpresaRepo) Restore(ctx context.Context, id string) (*models.Empresa, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	docID, err := empresaID(id)
+	if err != nil {
+		return nil, err
+	}
+	var stamp models.Empresa
+	stampUpdate(ctx, &stamp)
+	update := bson.M{"$set": bson.M{"deleted_at": nil, "updated_at": stamp.UpdatedAt, "updated_by": stamp.UpdatedBy}}
+	filter := bson.M{"_id": docID, "deleted_at": bson.M{"$type": "date"}}
+	e, err := r.write(ctx, filter, OpRestore, update)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	return e, err
+}
+
+// Purge remove definitivamente as empresas excluídas antes de before, uma
+// a uma, junto com a redação do histórico e a entrada do expurgo (veja tx).
+func (r *EmpresaRepo) Purge(ctx context.Context, before time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	filter := bson.M{"deleted_at": bson.M{"$type": "date", "$lt": before}}
+	cur, err := r.col.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	var candidates []models.Empresa
+	if err := cur.All(ctx, &candidates); err != nil {
+		return nil, mongoError(err)
+	}
+
+	at, by := now(), auth.PrincipalFrom(ctx)
+	purged := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		docID, err := empresaID(c.ID)
+		if err != nil {
+			return purged, err
+		}
+		err = r.tx(ctx, func(ctx context.Context) error {
+			var e models.Empresa
+			one := bson.M{"_id": docID, "deleted_at": filter["deleted_at"]}
+			if err := r.col.FindOneAndDelete(ctx, one).Decode(&e); err != nil {
+				return err
+			}
+			if err := r.redact(ctx, e.ID); err != nil {
+				return err
+			}
+			return r.append(ctx, purgeEntry(e, at, by))
+		})
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// restored or purged concurrently
+			continue
+		}
+		if err != nil {
+			return purged, mongoError(err)
+		}
+		purged = append(purged, c.ID)
+	}
+	return purged, nil
+}
+
+// redact apaga os dados da empresa id das entradas do histórico e do
+// outbox, como a função redact.
+func (r *EmpresaRepo) redact(ctx context.Context, id string) error {
+	redacted := func(prefix string) bson.M {
+		return bson.M{"$set": bson.M{
+			prefix + "snapshot":           nil,
+			prefix + "changes.$[].before": nil,
+			prefix + "changes.$[].after":  nil,
+		}}
+	}
+	if _, err := r.hist.UpdateMany(ctx, bson.M{"empresa_id": id}, redacted("")); err != nil {
+		return err
+	}
+	if r.outbox == nil {
+		return nil
+	}
+	_, err := r.outbox.UpdateMany(ctx, bson.M{"event.empresa_id": id}, redacted("event."))
+	return err
+}
+
+// History devolve as entradas do histórico da empresa id, sem snapshots.
+func (r *EmpresaRepo) History(ctx context.Context, id string) ([]HistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if _, err := empresaID(id); err != nil {
+		return nil, err
+	}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "version", Value: 1}}).
+		SetProjection(bson.M{"snapshot": 0})
+	cur, err := r.hist.Find(ctx, bson.M{"empresa_id": id}, opts)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	defer cur.Close(ctx)
+	entries := make([]HistoryEntry, 0)
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, mongoError(err)
+	}
+	return entries, nil
+}
+
+func (r *EmpresaRepo) HistoryVersion(ctx context.Context, id string, version int64) (*HistoryEntry, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if _, err := empresaID(id); err != nil {
+		return nil, err
+	}
+	var entry HistoryEntry
+	err := r.hist.FindOne(ctx, bson.M{"empresa_id": id, "version": version}).Decode(&entry)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	return &entry, nil
+}
+
+// PendingEvents devolve as entradas do outbox ainda não entregues, em ordem
+// de gravação. Sem WithOutbox a lista é sempre vazia.
+func (r *EmpresaRepo) PendingEvents(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	entries := make([]OutboxEntry, 0)
+	if r.outbox == nil {
+		return entries, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cur, err := r.outbox.Find(ctx, bson.M{"delivered_at": bson.M{"$type": "null"}}, opts)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	defer cur.Close(ctx)
+	if err := cur.All(ctx, &entries); err != nil {
+		return nil, mongoError(err)
+	}
+	return entries, nil
+}
+
+func (r *EmpresaRepo) MarkDelivered(ctx context.Context, id string) error {
+	return r.updateOutbox(ctx, id, bson.M{"$set": bson.M{"delivered_at": now()}})
+}
+
+func (r *EmpresaRepo) MarkFailed(ctx context.Context, id string, cause error) error {
+	return r.updateOutbox(ctx, id, bson.M{
+		"$inc": bson.M{"attempts": 1},
+		"$set": bson.M{"last_error": cause.Error()},
+	})
+}
+
+// updateOutbox aplica update à entrada id do outbox.
+func (r *EmpresaRepo) updateOutbox(ctx context.Context, id string, update bson.M) error {
+	objID, err := parseID(id)
+	if err != nil {
+		return err
+	}
+	if r.outbox == nil {
+		return ErrNotFound
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	res, err := r.outbox.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	if err != nil {
+		return mongoError(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// versionFilter seleciona o documento docID, se não estiver excluído,
+// restrito à versão esperada quando ela não é AnyVersion.
+func versionFilter(docID interface{}, expectedVersion int64) bson.M {
+	filter := active(bson.M{"_id": docID})
+	if expectedVersion != AnyVersion {
+		filter["version"] = expectedVersion
+	}
+	return filter
+}
+
+// notMatched traduz err de uma escrita condicional; quando nenhum documento
+// casou com versionFilter, distingue documento inexistente (ErrNotFound) de
+// versão desatualizada (ErrVersionConflict).
+func (r *EmpresaRepo) notMatched(ctx context.Context, docID interface{}, expectedVersion int64, err error) error {
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return mongoError(err)
+	}
+	if expectedVersion == AnyVersion {
+		return ErrNotFound
+	}
+	n, err := r.col.CountDocuments(ctx, active(bson.M{"_id": docID}), options.Count().SetLimit(1))
+	if err != nil {
+		return mongoError(err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return ErrVersionConflict
+}
+
+// setDoc monta o $set de uma substituição completa: todos os campos
+// persistidos de e, exceto _id, version, deleted_at e os de criação, que são
+// mantidos pelo repositório.
+func setDoc(e *models.Empresa) (bson.M, error) {
+	raw, err := bson.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var set bson.M
+	if err := bson.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"_id", "version", "created_at", "created_by", "deleted_at"} {
+		delete(set, k)
+	}
+	return set, nil
+}
+
+// CNPJReport é o resultado de ScanCNPJs.
+type CNPJReport struct {
+	Total int
+	// Deleted conta os documentos excluídos logicamente (deleted_at
+	// preenchido), que ficam fora de Duplicates.
+	Deleted int
+	// NonCanonical lista documentos cujo cnpj não está na forma canônica
+	// (com pontuação ou letras minúsculas), gravados antes da normalização.
+	NonCanonical []models.Empresa
+	// Duplicates agrupa, pela forma canônica, empresas ativas distintas
+	// cujos CNPJs só diferem por pontuação ou caixa. Uma empresa excluída e
+	// outra cadastrada depois com o mesmo CNPJ não são duplicidade.
+	Duplicates map[string][]models.Empresa
+}
+
+// ScanCNPJs percorre toda a coleção de empresas col procurando CNPJs fora
+// da forma canônica e duplicidades que o índice único não detectou. Recebe
+// a coleção, e não um EmpresaRepo, porque NewMongoEmpresaRepo migra
+// documentos e cria índices; ScanCNPJs não altera nada.
+func ScanCNPJs(ctx context.Context, col *mongo.Collection) (*CNPJReport, error) {
+	cur, err := col.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	byCNPJ := make(map[string][]models.Empresa)
+	rep := &CNPJReport{Duplicates: make(map[string][]models.Empresa)}
+	for cur.Next(ctx) {
+		var e models.Empresa
+		if err := cur.Decode(&e); err != nil {
+			return nil, err
+		}
+		rep.Total++
+		canon := validation.NormalizeCNPJ(e.CNPJ)
+		if canon != e.CNPJ {
+			rep.NonCanonical = append(rep.NonCanonical, e)
+		}
+		if e.DeletedAt != nil {
+			rep.Deleted++
+			continue
+		}
+		byCNPJ[canon] = append(byCNPJ[canon], e)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	for canon, items := range byCNPJ {
+		if len(items) > 1 {
+			rep.Duplicates[canon] = items
+		}
+	}
+	return rep, nil
+}
@@ -0,0 +1,137 @@
+package repository_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"matriz/internal/models"
+	"matriz/internal/repository"
+	"matriz/internal/repository/storetest"
+)
+
+// TestMongoEmpresaRepo roda a suíte de conformidade contra um mongod local,
+// que deve ser um replica set por causa das transações do outbox. Defina
+// MONGODB_TEST_URI (ex.: mongodb://localhost:27017/?replicaSet=rs0) para
+// executá-la.
+func TestMongoEmpresaRepo(t *testing.T) {
+	client := testClient(t)
+	n := 0
+	storetest.Run(t, func(t *testing.T) repository.EmpresaStore {
+		n++
+		name := fmt.Sprintf("empresas_%d_%d", time.Now().UnixNano(), n)
+		repo, err := repository.NewMongoEmpresaRepo(client, "matriz_test", name, repository.WithOutbox())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dropCollections(t, client, name)
+		return repo
+	})
+}
+
+// TestMongoStringID cobre empresas gravadas com _id string por versões
+// anteriores do serviço: continuam acessíveis pelo ID e a paginação passa
+// por elas nos dois sentidos.
+func TestMongoStringID(t *testing.T) {
+	client := testClient(t)
+	ctx := context.Background()
+	name := fmt.Sprintf("empresas_%d_legado", time.Now().UnixNano())
+	repo, err := repository.NewMongoEmpresaRepo(client, "matriz_test", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropCollections(t, client, name)
+
+	col := client.Database("matriz_test").Collection(name)
+	_, err = col.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "legado-1", "cnpj": "04252011000110", "nome_fantasia": "A", "version": int64(1), "deleted_at": nil},
+		bson.M{"_id": "legado-2", "cnpj": "11222333000181", "nome_fantasia": "B", "version": int64(1), "deleted_at": nil},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	created := make([]string, 0, 2)
+	for _, cnpj := range []string{"12345678000195", "33000167000101"} {
+		id, err := repo.Create(ctx, &models.Empresa{CNPJ: cnpj, NomeFantasia: "C"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, id)
+	}
+
+	if e, err := repo.Get(ctx, "legado-1"); err != nil || e.ID != "legado-1" {
+		t.Fatalf("Get(legado-1) = %+v, %v", e, err)
+	}
+	upd := models.Empresa{CNPJ: "04252011000110", NomeFantasia: "A2"}
+	if err := repo.Update(ctx, "legado-1", &upd, 1); err != nil || upd.Version != 2 {
+		t.Errorf("Update(legado-1) = %v, versão %d", err, upd.Version)
+	}
+	if err := repo.Delete(ctx, "legado-2", repository.AnyVersion); err != nil {
+		t.Errorf("Delete(legado-2) = %v", err)
+	}
+	if _, err := repo.Restore(ctx, "legado-2"); err != nil {
+		t.Errorf("Restore(legado-2) = %v", err)
+	}
+
+	all := append([]string{"legado-1", "legado-2"}, created...)
+	for _, tc := range []struct {
+		sort string
+		desc bool
+	}{{"_id", false}, {"_id", true}, {"nome_fantasia", false}, {"nome_fantasia", true}} {
+		var got []string
+		q := repository.ListQuery{Limit: 1, Sort: tc.sort, Desc: tc.desc}
+		for i := 0; i <= len(all); i++ {
+			res, err := repo.List(ctx, q)
+			if err != nil {
+				t.Fatalf("sort=%s desc=%v: %v", tc.sort, tc.desc, err)
+			}
+			for _, e := range res.Items {
+				got = append(got, e.ID)
+			}
+			if res.NextCursor == "" {
+				break
+			}
+			q.Cursor = res.NextCursor
+		}
+		if len(got) != len(all) {
+			t.Errorf("sort=%s desc=%v: páginas com %v, want os %d documentos", tc.sort, tc.desc, got, len(all))
+		}
+	}
+}
+
+// testClient conecta ao mongod de MONGODB_TEST_URI, pulando o teste se a
+// variável não estiver definida.
+func testClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI não definido")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("mongod indisponível em %s: %v", uri, err)
+	}
+	t.Cleanup(func() { _ = client.Disconnect(context.Background()) })
+	return client
+}
+
+// dropCollections remove ao fim do teste a coleção name e as auxiliares.
+func dropCollections(t *testing.T, client *mongo.Client, name string) {
+	t.Cleanup(func() {
+		db := client.Database("matriz_test")
+		for _, suffix := range []string{"", repository.HistorySuffix, repository.OutboxSuffix, repository.MigrationsSuffix} {
+			_ = db.Collection(name + suffix).Drop(context.Background())
+		}
+	})
+}
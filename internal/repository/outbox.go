@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// Outbox é a fila de eventos pendentes de publicação (transactional
+// outbox). Com WithOutbox, cada alteração de empresa grava, junto com ela,
+// uma OutboxEntry; um relay (pacote outbox) lê as pendentes em ordem,
+// publica e marca como entregues.
+type Outbox interface {
+	// PendingEvents devolve até limit entradas não entregues, da mais antiga
+	// para a mais nova.
+	PendingEvents(ctx context.Context, limit int) ([]OutboxEntry, error)
+	// MarkDelivered marca a entrada id como entregue.
+	MarkDelivered(ctx context.Context, id string) error
+	// MarkFailed registra uma tentativa de entrega da entrada id que falhou
+	// com cause; a entrada continua pendente.
+	MarkFailed(ctx context.Context, id string, cause error) error
+}
+
+// OutboxEntry é um evento do outbox: a alteração anunciada (Event, com o
+// snapshot da empresa) e o estado da entrega.
+type OutboxEntry struct {
+	ID          string       `bson:"_id,omitempty"`
+	Event       HistoryEntry `bson:"event"`
+	Attempts    int          `bson:"attempts"`
+	LastError   string       `bson:"last_error,omitempty"`
+	DeliveredAt *time.Time   `bson:"delivered_at"`
+}
+
+// Option configura um repositório em NewMongoEmpresaRepo ou
+// NewMemoryEmpresaRepo.
+type Option func(*storeOptions)
+
+type storeOptions struct {
+	outbox bool
+}
+
+// WithOutbox grava um evento no outbox a cada alteração, na mesma transação
+// da alteração. No MongoDB transações exigem um replica set (ou mongos), e
+// NewMongoEmpresaRepo falha sem elas.
+func WithOutbox() Option {
+	return func(o *storeOptions) { o.outbox = true }
+}
+
+func newStoreOptions(opts []Option) storeOptions {
+	var o storeOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"matriz/internal/models"
+)
+
+// Limites de página usados por List.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// SortFields são os campos aceitos em ListQuery.Sort; todos possuem índice.
+var SortFields = map[string]bool{
+	"_id":              true,
+	"cnpj":             true,
+	"nome_fantasia":    true,
+	"razao_social":     true,
+	"num_funcionarios": true,
+}
+
+// ErrInvalidQuery indica parâmetros de listagem inválidos (ordenação ou cursor).
+var ErrInvalidQuery = errors.New("consulta inválida")
+
+// ListQuery descreve uma página de List: filtros, ordenação e cursor.
+type ListQuery struct {
+	// Limit é o tamanho da página; 0 usa DefaultLimit e valores acima de
+	// MaxLimit são reduzidos.
+	Limit int
+	// Cursor é o valor opaco de ListResult.NextCursor da página anterior.
+	// Deve ser usado com os mesmos filtros e ordenação.
+	Cursor string
+	// Sort é um dos SortFields; vazio ordena por _id.
+	Sort string
+	Desc bool
+
+	// NomeFantasiaPrefix filtra por prefixo de nome_fantasia (diferencia
+	// maiúsculas de minúsculas, para usar o índice).
+	NomeFantasiaPrefix string
+	// RazaoSocialContains filtra por trecho de razao_social, sem diferenciar
+	// maiúsculas de minúsculas.
+	RazaoSocialContains string
+	// MinFuncionarios e MaxFuncionarios filtram num_funcionarios (inclusive).
+	MinFuncionarios *int
+	MaxFuncionarios *int
+}
+
+// ListResult é uma página de empresas.
+type ListResult struct {
+	Items []models.Empresa
+	// NextCursor é vazio na última página.
+	NextCursor string
+	// Total é a quantidade de empresas que atendem aos filtros.
+	Total int64
+}
+
+// SearchHit é um resultado de Search com a relevância calculada pelo índice
+// de texto (maior é mais relevante).
+type SearchHit struct {
+	models.Empresa `bson:",inline"`
+	Score          float64 `json:"score" bson:"score"`
+}
+
+// searchLimit aplica a Search os mesmos limites de página de List.
+func searchLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultLimit
+	}
+	if limit > MaxLimit {
+		return MaxLimit
+	}
+	return limit
+}
+
+// normalize aplica os padrões de ListQuery e valida a ordenação.
+func (q *ListQuery) normalize() error {
+	if q.Limit <= 0 {
+		q.Limit = DefaultLimit
+	}
+	if q.Limit > MaxLimit {
+		q.Limit = MaxLimit
+	}
+	if q.Sort == "" || q.Sort == "id" {
+		q.Sort = "_id"
+	}
+	if !SortFields[q.Sort] {
+		return fmt.Errorf("%w: ordenação por %q não suportada", ErrInvalidQuery, q.Sort)
+	}
+	return nil
+}
+
+// cursor é a posição após o último item de uma página: o valor do campo de
+// ordenação e o ID, que desempata valores iguais.
+type cursor struct {
+	Value interface{} `bson:"v"`
+	ID    string      `bson:"id"`
+}
+
+func encodeCursor(c cursor) (string, error) {
+	b, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(s string) (cursor, error) {
+	var c cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = bson.Unmarshal(b, &c)
+	}
+	if err != nil || c.ID == "" {
+		return c, fmt.Errorf("%w: cursor inválido", ErrInvalidQuery)
+	}
+	return c, nil
+}
+
+// sortValue devolve o valor do campo de ordenação de e.
+func sortValue(e *models.Empresa, field string) interface{} {
+	switch field {
+	case "cnpj":
+		return e.CNPJ
+	case "nome_fantasia":
+		return e.NomeFantasia
+	case "razao_social":
+		return e.RazaoSocial
+	case "num_funcionarios":
+		return e.NumFuncionarios
+	default:
+		return e.ID
+	}
+}
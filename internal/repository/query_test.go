@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Uma página terminada por empresa com _id string, de versões anteriores,
+// gera um cursor que volta a ser aceito.
+func TestCursorStringID(t *testing.T) {
+	s, err := encodeCursor(cursor{Value: "Loja", ID: "legado-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := decodeCursor(s)
+	if err != nil || c.ID != "legado-1" || c.Value != "Loja" {
+		t.Errorf("decodeCursor = %+v, %v", c, err)
+	}
+}
+
+func TestIDAfter(t *testing.T) {
+	obj := primitive.NewObjectID()
+	withOther := func(op string, id interface{}, other string) bson.M {
+		return bson.M{"$or": bson.A{
+			bson.M{"_id": bson.M{op: id}},
+			bson.M{"_id": bson.M{"$type": other}},
+		}}
+	}
+	for _, tt := range []struct {
+		op   string
+		id   interface{}
+		want bson.M
+	}{
+		// strings ordenam antes de ObjectIDs
+		{"$gt", obj, bson.M{"_id": bson.M{"$gt": obj}}},
+		{"$lt", obj, withOther("$lt", obj, "string")},
+		{"$gt", "legado-1", withOther("$gt", "legado-1", "objectId")},
+		{"$lt", "legado-1", bson.M{"_id": bson.M{"$lt": "legado-1"}}},
+	} {
+		if got := idAfter(tt.op, tt.id); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("idAfter(%s, %v) = %v, want %v", tt.op, tt.id, got, tt.want)
+		}
+	}
+}
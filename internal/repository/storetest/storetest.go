@@ -0,0 +1,791 @@
+// Package storetest contém a suíte de conformidade que toda implementação de
+// repository.EmpresaStore deve passar.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"matriz/internal/auth"
+	"matriz/internal/models"
+	"matriz/internal/repository"
+)
+
+// CNPJs válidos usados pela suíte.
+var cnpjs = []string{
+	"04252011000110",
+	"11222333000181",
+	"12ABC34501DE35",
+	"12345678000195",
+	"33000167000101",
+	"60701190000104",
+}
+
+// Run executa a suíte sobre os stores devolvidos por newStore, que deve
+// criar um store vazio e isolado a cada chamada. O subteste Outbox exige
+// stores que implementem repository.Outbox criados com WithOutbox.
+func Run(t *testing.T, newStore func(t *testing.T) repository.EmpresaStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s repository.EmpresaStore)
+	}{
+		{"CreateAndGet", testCreateAndGet},
+		{"UniqueCNPJ", testUniqueCNPJ},
+		{"ConcurrentCreate", testConcurrentCreate},
+		{"GetNotFound", testGetNotFound},
+		{"InvalidID", testInvalidID},
+		{"Update", testUpdate},
+		{"UpdateDuplicateCNPJ", testUpdateDuplicateCNPJ},
+		{"Patch", testPatch},
+		{"PatchErrors", testPatchErrors},
+		{"Delete", testDelete},
+		{"SoftDelete", testSoftDelete},
+		{"Purge", testPurge},
+		{"History", testHistory},
+		{"Outbox", testOutbox},
+		{"Versions", testVersions},
+		{"UpdatedAt", testUpdatedAt},
+		{"Audit", testAudit},
+		{"ListPagination", testListPagination},
+		{"ListFilters", testListFilters},
+		{"Search", testSearch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func mustCreate(t *testing.T, s repository.EmpresaStore, e models.Empresa) string {
+	t.Helper()
+	id, err := s.Create(context.Background(), &e)
+	if err != nil {
+		t.Fatalf("Create(%s): %v", e.CNPJ, err)
+	}
+	if id == "" {
+		t.Fatalf("Create(%s): id vazio", e.CNPJ)
+	}
+	return id
+}
+
+func testCreateAndGet(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	id := mustCreate(t, s, models.Empresa{CNPJ: "04.252.011/0001-10", NomeFantasia: "Acme", NumFuncionarios: 10})
+	got, err := s.Get(ctx, id)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if got.ID != id || got.CNPJ != "04252011000110" || got.NomeFantasia != "Acme" || got.NumFuncionarios != 10 {
+		t.Errorf("Get = %+v", got)
+	}
+	got, err = s.GetByCNPJ(ctx, "04.252.011/0001-10")
+	if err != nil || got.ID != id {
+		t.Errorf("GetByCNPJ com pontuação = %+v, %v", got, err)
+	}
+	other := mustCreate(t, s, models.Empresa{CNPJ: "12abc34501de35"})
+	if other == id {
+		t.Errorf("IDs repetidos: %s", id)
+	}
+	got, err = s.GetByCNPJ(ctx, "12.ABC.345/01DE-35")
+	if err != nil || got.ID != other {
+		t.Errorf("GetByCNPJ alfanumérico = %+v, %v", got, err)
+	}
+}
+
+func testUniqueCNPJ(t *testing.T, s repository.EmpresaStore) {
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+	e := models.Empresa{CNPJ: "04.252.011/0001-10"}
+	if _, err := s.Create(context.Background(), &e); !errors.Is(err, repository.ErrDuplicateCNPJ) {
+		t.Fatalf("Create com CNPJ repetido (outra pontuação) = %v, want ErrDuplicateCNPJ", err)
+	}
+}
+
+func testConcurrentCreate(t *testing.T, s repository.EmpresaStore) {
+	const n = 8
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ok := 0
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			e := models.Empresa{CNPJ: cnpjs[1]}
+			if _, err := s.Create(context.Background(), &e); err == nil {
+				mu.Lock()
+				ok++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if ok != 1 {
+		t.Errorf("%d criações concorrentes com o mesmo CNPJ tiveram sucesso, want 1", ok)
+	}
+}
+
+func testGetNotFound(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	if _, err := s.Get(ctx, "65f1c0ffee0000000000beef"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get de ID inexistente = %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetByCNPJ(ctx, cnpjs[2]); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetByCNPJ de CNPJ inexistente = %v, want ErrNotFound", err)
+	}
+	e := models.Empresa{CNPJ: cnpjs[2]}
+	if err := s.Update(ctx, "65f1c0ffee0000000000beef", &e, repository.AnyVersion); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Update de ID inexistente = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, "65f1c0ffee0000000000beef", repository.AnyVersion); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Delete de ID inexistente = %v, want ErrNotFound", err)
+	}
+}
+
+func testInvalidID(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	if _, err := s.Get(ctx, ""); !errors.Is(err, repository.ErrInvalidID) {
+		t.Errorf("Get = %v, want ErrInvalidID", err)
+	}
+	e := models.Empresa{CNPJ: cnpjs[0]}
+	if err := s.Update(ctx, "", &e, repository.AnyVersion); !errors.Is(err, repository.ErrInvalidID) {
+		t.Errorf("Update = %v, want ErrInvalidID", err)
+	}
+	if err := s.Delete(ctx, "", repository.AnyVersion); !errors.Is(err, repository.ErrInvalidID) {
+		t.Errorf("Delete = %v, want ErrInvalidID", err)
+	}
+	// IDs que não são ObjectID valem como _id string, de versões anteriores
+	if _, err := s.Get(ctx, "1"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get de ID string = %v, want ErrNotFound", err)
+	}
+	if err := s.Update(ctx, "xyz", &e, repository.AnyVersion); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Update de ID string = %v, want ErrNotFound", err)
+	}
+}
+
+func testUpdate(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	id := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "Antes"})
+	e := models.Empresa{CNPJ: "11.222.333/0001-81", NomeFantasia: "Depois", NumFuncionarios: 3}
+	if err := s.Update(ctx, id, &e, repository.AnyVersion); err != nil {
+		t.Fatalf("Update: %v", err)
+	}
+	got, err := s.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NomeFantasia != "Depois" || got.CNPJ != cnpjs[1] || got.NumFuncionarios != 3 {
+		t.Errorf("Get após Update = %+v", got)
+	}
+	if _, err := s.GetByCNPJ(ctx, cnpjs[0]); err == nil {
+		t.Error("CNPJ antigo ainda encontrado após Update")
+	}
+	// o CNPJ antigo fica livre para outra empresa
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+}
+
+func testUpdateDuplicateCNPJ(t *testing.T, s repository.EmpresaStore) {
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+	id := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[1]})
+	e := models.Empresa{CNPJ: cnpjs[0]}
+	if err := s.Update(context.Background(), id, &e, repository.AnyVersion); !errors.Is(err, repository.ErrDuplicateCNPJ) {
+		t.Errorf("Update para CNPJ de outra empresa = %v, want ErrDuplicateCNPJ", err)
+	}
+}
+
+func testPatch(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	id := mustCreate(t, s, models.Empresa{
+		CNPJ: cnpjs[0], NomeFantasia: "Antes", RazaoSocial: "Antes LTDA",
+		Endereco: "Rua A", NumFuncionarios: 10,
+	})
+	version, err := s.Patch(ctx, id, repository.Changes{
+		Set:   map[string]interface{}{"nome_fantasia": "Depois", "num_funcionarios": 120, "cnpj": "11.222.333/0001-81"},
+		Unset: []string{"endereco"},
+	}, repository.AnyVersion)
+	if err != nil {
+		t.Fatalf("Patch: %v", err)
+	}
+	got, err := s.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.Empresa{
+		ID: id, CNPJ: cnpjs[1], NomeFantasia: "Depois", RazaoSocial: "Antes LTDA",
+		NumFuncionarios: 120, Version: 2,
+		CreatedAt: got.CreatedAt, UpdatedAt: got.UpdatedAt,
+	}
+	if *got != want || version != 2 {
+		t.Errorf("Get após Patch = %+v, want %+v", got, want)
+	}
+	if _, err := s.GetByCNPJ(ctx, cnpjs[1]); err != nil {
+		t.Errorf("GetByCNPJ do novo CNPJ: %v", err)
+	}
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+	if version, err := s.Patch(ctx, id, repository.Changes{}, 2); err != nil || version != 2 {
+		t.Errorf("Patch vazio = %d, %v, want 2, nil", version, err)
+	}
+}
+
+func testPatchErrors(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+	id := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[1]})
+	dup := repository.Changes{Set: map[string]interface{}{"cnpj": cnpjs[0]}}
+	if _, err := s.Patch(ctx, id, dup, repository.AnyVersion); !errors.Is(err, repository.ErrDuplicateCNPJ) {
+		t.Errorf("Patch para CNPJ de outra empresa = %v, want ErrDuplicateCNPJ", err)
+	}
+	name := repository.Changes{Set: map[string]interface{}{"nome_fantasia": "X"}}
+	for _, missing := range []repository.Changes{name, {}} {
+		if _, err := s.Patch(ctx, "65f1c0ffee0000000000beef", missing, repository.AnyVersion); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("Patch(%+v) de ID inexistente = %v, want ErrNotFound", missing, err)
+		}
+	}
+	if _, err := s.Patch(ctx, "", name, repository.AnyVersion); !errors.Is(err, repository.ErrInvalidID) {
+		t.Errorf("Patch com ID inválido = %v, want ErrInvalidID", err)
+	}
+	for _, ch := range []repository.Changes{
+		{Set: map[string]interface{}{"_id": "65f1c0ffee0000000000beef"}},
+		{Unset: []string{"status_pcd"}},
+		{Set: map[string]interface{}{"endereco": "x"}, Unset: []string{"endereco"}},
+	} {
+		if _, err := s.Patch(ctx, id, ch, repository.AnyVersion); !errors.Is(err, repository.ErrInvalidChange) {
+			t.Errorf("Patch(%+v) = %v, want ErrInvalidChange", ch, err)
+		}
+	}
+}
+
+func testDelete(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	id := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+	if err := s.Delete(ctx, id, repository.AnyVersion); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Get após Delete = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, id, repository.AnyVersion); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("segundo Delete = %v, want ErrNotFound", err)
+	}
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+}
+
+func testSoftDelete(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	id := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "Padaria Excluída"})
+	if _, err := s.Restore(ctx, id); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Restore de empresa ativa = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, id, 1); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.GetByCNPJ(ctx, cnpjs[0]); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("GetByCNPJ após Delete = %v, want ErrNotFound", err)
+	}
+	if res, err := s.List(ctx, repository.ListQuery{}); err != nil || len(res.Items) != 0 || res.Total != 0 {
+		t.Errorf("List após Delete = %+v, %v, want vazia", res, err)
+	}
+	if hits, err := s.Search(ctx, "padaria", 10); err != nil || len(hits) != 0 {
+		t.Errorf("Search após Delete = %d resultados, %v, want 0", len(hits), err)
+	}
+	e := models.Empresa{CNPJ: cnpjs[0]}
+	if err := s.Update(ctx, id, &e, repository.AnyVersion); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Update após Delete = %v, want ErrNotFound", err)
+	}
+	name := repository.Changes{Set: map[string]interface{}{"nome_fantasia": "x"}}
+	if _, err := s.Patch(ctx, id, name, repository.AnyVersion); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Patch após Delete = %v, want ErrNotFound", err)
+	}
+
+	restored, err := s.Restore(auth.WithPrincipal(ctx, "admin"), id)
+	if err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	if restored.DeletedAt != nil || restored.Version != 3 || restored.UpdatedBy != "admin" || restored.NomeFantasia != "Padaria Excluída" {
+		t.Errorf("Restore = %+v, want ativa na versão 3 restaurada por admin", restored)
+	}
+	if got, err := s.Get(ctx, id); err != nil || got.Version != 3 {
+		t.Fatalf("Get após Restore = %+v, %v", got, err)
+	}
+
+	// o CNPJ de uma empresa excluída fica livre; restaurá-la depois conflita
+	if err := s.Delete(ctx, id, 3); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+	if _, err := s.Restore(ctx, id); !errors.Is(err, repository.ErrDuplicateCNPJ) {
+		t.Errorf("Restore com CNPJ em uso = %v, want ErrDuplicateCNPJ", err)
+	}
+}
+
+func testPurge(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	ativa := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0]})
+	excluida := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[1]})
+	if err := s.Delete(ctx, excluida, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err := s.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || len(ids) != 0 {
+		t.Errorf("Purge antes da exclusão = %v, %v, want nenhuma", ids, err)
+	}
+	ids, err := s.Purge(auth.WithPrincipal(ctx, "admin"), time.Now().Add(time.Hour))
+	if err != nil || len(ids) != 1 || ids[0] != excluida {
+		t.Errorf("Purge após a exclusão = %v, %v, want [%s]", ids, err, excluida)
+	}
+	if _, err := s.Restore(ctx, excluida); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Restore após Purge = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, ativa); err != nil {
+		t.Errorf("Purge removeu empresa ativa: %v", err)
+	}
+
+	// o histórico é mantido, sem os dados da empresa, e registra o expurgo
+	h, err := s.History(ctx, excluida)
+	if err != nil || len(h) != 3 {
+		t.Fatalf("History após Purge = %d entradas, %v, want 3", len(h), err)
+	}
+	if h[0].Op != repository.OpCreate || changedFields(h[0]) == "" {
+		t.Errorf("History[0] após Purge = %+v, want create com os campos alterados", h[0])
+	}
+	for _, entry := range h {
+		for _, c := range entry.Changes {
+			if c.Before != nil || c.After != nil {
+				t.Errorf("versão %d: alteração de %s = %v -> %v, want valores apagados", entry.Version, c.Field, c.Before, c.After)
+			}
+		}
+	}
+	if p := h[2]; p.Op != repository.OpPurge || p.Version != 3 || p.By != "admin" || p.At.IsZero() || len(p.Changes) != 0 {
+		t.Errorf("entrada do expurgo = %+v, want purge na versão 3 por admin", p)
+	}
+	for v := int64(1); v <= 3; v++ {
+		if entry, err := s.HistoryVersion(ctx, excluida, v); err != nil || entry.Snapshot != nil {
+			t.Errorf("HistoryVersion(%d) após Purge = %+v, %v, want sem snapshot", v, entry, err)
+		}
+	}
+	if entry, err := s.HistoryVersion(ctx, ativa, 1); err != nil || entry.Snapshot == nil {
+		t.Errorf("Purge apagou o snapshot de empresa ativa: %+v, %v", entry, err)
+	}
+
+	// eventos da empresa expurgada também perdem os dados
+	if o, ok := s.(repository.Outbox); ok {
+		pending, err := o.PendingEvents(ctx, 10)
+		if err != nil {
+			t.Fatalf("PendingEvents: %v", err)
+		}
+		var last repository.HistoryEntry
+		for _, entry := range pending {
+			if entry.Event.EmpresaID == excluida {
+				if entry.Event.Snapshot != nil {
+					t.Errorf("evento %s da versão %d mantém o snapshot", entry.Event.Op, entry.Event.Version)
+				}
+				last = entry.Event
+			}
+		}
+		if last.Op != repository.OpPurge {
+			t.Errorf("último evento da empresa expurgada = %+v, want purge", last)
+		}
+	}
+}
+
+func testHistory(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	e := models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "Padaria", RazaoSocial: "Antiga LTDA"}
+	id, err := s.Create(auth.WithPrincipal(ctx, "ana"), &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.RazaoSocial = "Nova LTDA"
+	if err := s.Update(auth.WithPrincipal(ctx, "bia"), id, &e, 1); err != nil {
+		t.Fatal(err)
+	}
+	// escritas rejeitadas ou sem alteração não entram no histórico
+	if err := s.Update(ctx, id, &e, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("Update em versão antiga = %v", err)
+	}
+	if _, err := s.Patch(ctx, id, repository.Changes{}, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	name := repository.Changes{Set: map[string]interface{}{"nome_fantasia": "Padaria Nova"}, Unset: []string{"endereco"}}
+	if _, err := s.Patch(ctx, id, name, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, id, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Restore(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := s.History(ctx, id)
+	if err != nil {
+		t.Fatalf("History: %v", err)
+	}
+	wantOps := []string{repository.OpCreate, repository.OpUpdate, repository.OpUpdate, repository.OpDelete, repository.OpRestore}
+	if len(h) != len(wantOps) {
+		t.Fatalf("History: %d entradas, want %d: %+v", len(h), len(wantOps), h)
+	}
+	for i, entry := range h {
+		if entry.EmpresaID != id || entry.Version != int64(i+1) || entry.Op != wantOps[i] || entry.Snapshot != nil || entry.At.IsZero() {
+			t.Errorf("History[%d] = %+v, want %s na versão %d sem snapshot", i, entry, wantOps[i], i+1)
+		}
+	}
+	if h[0].By != "ana" || h[1].By != "bia" || h[2].By != "" {
+		t.Errorf("History: autores = %q, %q, %q", h[0].By, h[1].By, h[2].By)
+	}
+	if fields := changedFields(h[0]); fields != "cnpj nome_fantasia razao_social" {
+		t.Errorf("History create: campos = %q", fields)
+	}
+	if c := h[1].Changes; len(c) != 1 || c[0].Field != "razao_social" || c[0].Before != "Antiga LTDA" || c[0].After != "Nova LTDA" {
+		t.Errorf("History update: alterações = %+v", c)
+	}
+	if fields := changedFields(h[2]); fields != "nome_fantasia" {
+		t.Errorf("History patch: campos = %q", fields)
+	}
+	if c := h[3].Changes; len(c) != 1 || c[0].Field != "deleted_at" || c[0].Before != nil || c[0].After == nil {
+		t.Errorf("History delete: alterações = %+v", c)
+	}
+	if c := h[4].Changes; len(c) != 1 || c[0].Field != "deleted_at" || c[0].After != nil {
+		t.Errorf("History restore: alterações = %+v", c)
+	}
+
+	v1, err := s.HistoryVersion(ctx, id, 1)
+	if err != nil || v1.Snapshot == nil || v1.Snapshot.RazaoSocial != "Antiga LTDA" || v1.Snapshot.Version != 1 || v1.Snapshot.ID != id {
+		t.Fatalf("HistoryVersion(1) = %+v, %v", v1, err)
+	}
+	v4, err := s.HistoryVersion(ctx, id, 4)
+	if err != nil || v4.Snapshot.NomeFantasia != "Padaria Nova" || v4.Snapshot.DeletedAt == nil || v4.Snapshot.UpdatedAt != h[3].At {
+		t.Errorf("HistoryVersion(4) = %+v, %v", v4, err)
+	}
+	if _, err := s.HistoryVersion(ctx, id, 6); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("HistoryVersion inexistente = %v, want ErrNotFound", err)
+	}
+	if h, err := s.History(ctx, "000000000000000000000000"); err != nil || len(h) != 0 {
+		t.Errorf("History de empresa inexistente = %+v, %v, want vazio", h, err)
+	}
+	if _, err := s.History(ctx, ""); !errors.Is(err, repository.ErrInvalidID) {
+		t.Errorf("History com ID inválido = %v, want ErrInvalidID", err)
+	}
+}
+
+func testOutbox(t *testing.T, s repository.EmpresaStore) {
+	o, ok := s.(repository.Outbox)
+	if !ok {
+		t.Skip("store sem outbox")
+	}
+	ctx := context.Background()
+	e := models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "Padaria"}
+	id, err := s.Create(ctx, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.NomeFantasia = "Padaria Nova"
+	if err := s.Update(ctx, id, &e, 1); err != nil {
+		t.Fatal(err)
+	}
+	// escritas rejeitadas não geram eventos
+	if err := s.Update(ctx, id, &e, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Fatalf("Update em versão antiga = %v", err)
+	}
+	if err := s.Delete(ctx, id, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := o.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("PendingEvents: %v", err)
+	}
+	wantOps := []string{repository.OpCreate, repository.OpUpdate, repository.OpDelete}
+	if len(pending) != len(wantOps) {
+		t.Fatalf("PendingEvents: %d entradas, want %d", len(pending), len(wantOps))
+	}
+	for i, entry := range pending {
+		ev := entry.Event
+		if entry.ID == "" || ev.Op != wantOps[i] || ev.EmpresaID != id || ev.Version != int64(i+1) ||
+			ev.Snapshot == nil || ev.Snapshot.Version != ev.Version || entry.DeliveredAt != nil {
+			t.Errorf("PendingEvents[%d] = %+v, want %s na versão %d", i, entry, wantOps[i], i+1)
+		}
+	}
+	if pending[1].Event.Snapshot.NomeFantasia != "Padaria Nova" {
+		t.Errorf("evento de update: snapshot = %+v", pending[1].Event.Snapshot)
+	}
+
+	if err := o.MarkFailed(ctx, pending[0].ID, errors.New("broker fora do ar")); err != nil {
+		t.Fatalf("MarkFailed: %v", err)
+	}
+	if p, err := o.PendingEvents(ctx, 1); err != nil || len(p) != 1 || p[0].ID != pending[0].ID ||
+		p[0].Attempts != 1 || p[0].LastError != "broker fora do ar" {
+		t.Errorf("PendingEvents após MarkFailed = %+v, %v", p, err)
+	}
+	if err := o.MarkDelivered(ctx, pending[0].ID); err != nil {
+		t.Fatalf("MarkDelivered: %v", err)
+	}
+	if p, err := o.PendingEvents(ctx, 10); err != nil || len(p) != 2 || p[0].ID != pending[1].ID {
+		t.Errorf("PendingEvents após MarkDelivered = %+v, %v", p, err)
+	}
+	if err := o.MarkDelivered(ctx, "000000000000000000000000"); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("MarkDelivered inexistente = %v, want ErrNotFound", err)
+	}
+	if err := o.MarkDelivered(ctx, "abc"); !errors.Is(err, repository.ErrInvalidID) {
+		t.Errorf("MarkDelivered com ID inválido = %v, want ErrInvalidID", err)
+	}
+}
+
+// changedFields lista os campos alterados de uma entrada, separados por espaço.
+func changedFields(entry repository.HistoryEntry) string {
+	var fields []string
+	for _, c := range entry.Changes {
+		fields = append(fields, c.Field)
+	}
+	return strings.Join(fields, " ")
+}
+
+func testVersions(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	e := models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "v1"}
+	id, err := s.Create(ctx, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version != 1 {
+		t.Fatalf("Create: Version = %d, want 1", e.Version)
+	}
+	e.NomeFantasia = "v2"
+	if err := s.Update(ctx, id, &e, 1); err != nil || e.Version != 2 {
+		t.Fatalf("Update na versão atual = %v, Version %d, want nil, 2", err, e.Version)
+	}
+	stale := models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "antigo"}
+	if err := s.Update(ctx, id, &stale, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Update em versão antiga = %v, want ErrVersionConflict", err)
+	}
+	name := repository.Changes{Set: map[string]interface{}{"nome_fantasia": "v3"}}
+	if _, err := s.Patch(ctx, id, name, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Patch em versão antiga = %v, want ErrVersionConflict", err)
+	}
+	if _, err := s.Patch(ctx, id, repository.Changes{}, 1); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Patch vazio em versão antiga = %v, want ErrVersionConflict", err)
+	}
+	if v, err := s.Patch(ctx, id, name, 2); err != nil || v != 3 {
+		t.Errorf("Patch na versão atual = %d, %v, want 3, nil", v, err)
+	}
+	if err := s.Delete(ctx, id, 2); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Delete em versão antiga = %v, want ErrVersionConflict", err)
+	}
+	got, err := s.Get(ctx, id)
+	if err != nil || got.NomeFantasia != "v3" || got.Version != 3 {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+	if err := s.Update(ctx, "65f1c0ffee0000000000beef", &e, 3); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Update condicional de ID inexistente = %v, want ErrNotFound", err)
+	}
+	if err := s.Delete(ctx, id, 3); err != nil {
+		t.Errorf("Delete na versão atual = %v", err)
+	}
+}
+
+func testUpdatedAt(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Second)
+	e := models.Empresa{CNPJ: cnpjs[0], UpdatedAt: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	id, err := s.Create(ctx, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.UpdatedAt.Before(start) || !got.UpdatedAt.Equal(e.UpdatedAt) {
+		t.Fatalf("Create: UpdatedAt = %v (gravado %v), want instante atual", e.UpdatedAt, got.UpdatedAt)
+	}
+	created := got.UpdatedAt
+
+	time.Sleep(2 * time.Millisecond)
+	e.UpdatedAt = time.Time{}
+	if err := s.Update(ctx, id, &e, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = s.Get(ctx, id); !got.UpdatedAt.After(created) || !got.UpdatedAt.Equal(e.UpdatedAt) {
+		t.Errorf("Update: UpdatedAt = %v (gravado %v), want depois de %v", e.UpdatedAt, got.UpdatedAt, created)
+	}
+	updated := got.UpdatedAt
+
+	if _, err := s.Patch(ctx, id, repository.Changes{}, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = s.Get(ctx, id); !got.UpdatedAt.Equal(updated) {
+		t.Errorf("Patch vazio alterou UpdatedAt: %v -> %v", updated, got.UpdatedAt)
+	}
+	time.Sleep(2 * time.Millisecond)
+	name := repository.Changes{Set: map[string]interface{}{"nome_fantasia": "X"}}
+	if _, err := s.Patch(ctx, id, name, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = s.Get(ctx, id); !got.UpdatedAt.After(updated) {
+		t.Errorf("Patch: UpdatedAt = %v, want depois de %v", got.UpdatedAt, updated)
+	}
+}
+
+func testAudit(t *testing.T, s repository.EmpresaStore) {
+	ana := auth.WithPrincipal(context.Background(), "ana")
+	bia := auth.WithPrincipal(context.Background(), "bia")
+	forjado := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := models.Empresa{
+		CNPJ:      cnpjs[0],
+		CreatedAt: forjado, CreatedBy: "mallory",
+		UpdatedAt: forjado, UpdatedBy: "mallory",
+	}
+	id, err := s.Create(ana, &e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ana, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CreatedBy != "ana" || got.UpdatedBy != "ana" || got.CreatedAt.Equal(forjado) ||
+		!got.CreatedAt.Equal(got.UpdatedAt) {
+		t.Fatalf("Create: auditoria = %v %q %v %q", got.CreatedAt, got.CreatedBy, got.UpdatedAt, got.UpdatedBy)
+	}
+	created := got.CreatedAt
+
+	e = models.Empresa{CNPJ: cnpjs[0], CreatedAt: forjado, CreatedBy: "mallory", UpdatedBy: "mallory"}
+	if err := s.Update(bia, id, &e, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if e.CreatedBy != "ana" || !e.CreatedAt.Equal(created) || e.UpdatedBy != "bia" {
+		t.Errorf("Update: e = %v %q %q", e.CreatedAt, e.CreatedBy, e.UpdatedBy)
+	}
+	if got, _ = s.Get(ana, id); got.CreatedBy != "ana" || !got.CreatedAt.Equal(created) || got.UpdatedBy != "bia" {
+		t.Errorf("Get após Update: auditoria = %v %q %q", got.CreatedAt, got.CreatedBy, got.UpdatedBy)
+	}
+
+	name := repository.Changes{Set: map[string]interface{}{"nome_fantasia": "X"}}
+	if _, err := s.Patch(context.Background(), id, name, repository.AnyVersion); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = s.Get(ana, id); got.CreatedBy != "ana" || !got.CreatedAt.Equal(created) || got.UpdatedBy != "" {
+		t.Errorf("Get após Patch anônimo: auditoria = %v %q %q", got.CreatedAt, got.CreatedBy, got.UpdatedBy)
+	}
+	for _, ch := range []repository.Changes{
+		{Set: map[string]interface{}{"created_by": "mallory"}},
+		{Set: map[string]interface{}{"updated_at": forjado}},
+		{Unset: []string{"created_at"}},
+	} {
+		if _, err := s.Patch(bia, id, ch, repository.AnyVersion); !errors.Is(err, repository.ErrInvalidChange) {
+			t.Errorf("Patch(%+v) = %v, want ErrInvalidChange", ch, err)
+		}
+	}
+}
+
+func testListPagination(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	nomes := []string{"Eta", "Beta", "Delta", "Alfa", "Gama"}
+	for i, n := range nomes {
+		mustCreate(t, s, models.Empresa{CNPJ: cnpjs[i], NomeFantasia: n, NumFuncionarios: 10 * (i % 2)})
+	}
+	for _, tc := range []struct {
+		sort string
+		desc bool
+		want []string
+	}{
+		{sort: "nome_fantasia", want: []string{"Alfa", "Beta", "Delta", "Eta", "Gama"}},
+		{sort: "nome_fantasia", desc: true, want: []string{"Gama", "Eta", "Delta", "Beta", "Alfa"}},
+		{sort: "", want: nil},
+		{sort: "num_funcionarios", want: nil},
+	} {
+		q := repository.ListQuery{Limit: 2, Sort: tc.sort, Desc: tc.desc}
+		var got []string
+		seen := map[string]bool{}
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatalf("sort=%q: paginação não termina", tc.sort)
+			}
+			res, err := s.List(ctx, q)
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if res.Total != int64(len(nomes)) {
+				t.Errorf("sort=%q: Total = %d, want %d", tc.sort, res.Total, len(nomes))
+			}
+			for _, e := range res.Items {
+				if seen[e.ID] {
+					t.Errorf("sort=%q: %s repetido entre páginas", tc.sort, e.NomeFantasia)
+				}
+				seen[e.ID] = true
+				got = append(got, e.NomeFantasia)
+			}
+			if res.NextCursor == "" {
+				break
+			}
+			q.Cursor = res.NextCursor
+		}
+		if len(got) != len(nomes) {
+			t.Errorf("sort=%q: %d itens, want %d: %v", tc.sort, len(got), len(nomes), got)
+		}
+		if tc.want != nil {
+			for i := range tc.want {
+				if i < len(got) && got[i] != tc.want[i] {
+					t.Errorf("sort=%q desc=%v: ordem %v, want %v", tc.sort, tc.desc, got, tc.want)
+					break
+				}
+			}
+		}
+	}
+	if _, err := s.List(ctx, repository.ListQuery{Sort: "endereco"}); !errors.Is(err, repository.ErrInvalidQuery) {
+		t.Errorf("List com ordenação não suportada = %v, want ErrInvalidQuery", err)
+	}
+	if _, err := s.List(ctx, repository.ListQuery{Cursor: "%%%"}); !errors.Is(err, repository.ErrInvalidQuery) {
+		t.Errorf("List com cursor inválido = %v, want ErrInvalidQuery", err)
+	}
+}
+
+func testListFilters(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "Padaria Central", RazaoSocial: "Pães Central LTDA", NumFuncionarios: 5})
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[1], NomeFantasia: "Padaria Norte", RazaoSocial: "Norte Alimentos SA", NumFuncionarios: 150})
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[2], NomeFantasia: "Mercado Sul", RazaoSocial: "Sul Comércio LTDA", NumFuncionarios: 600})
+	min, max := 100, 1000
+	for _, tc := range []struct {
+		name string
+		q    repository.ListQuery
+		want int
+	}{
+		{"prefixo", repository.ListQuery{NomeFantasiaPrefix: "Padaria"}, 2},
+		{"prefixo não é trecho", repository.ListQuery{NomeFantasiaPrefix: "Central"}, 0},
+		{"trecho sem caixa", repository.ListQuery{RazaoSocialContains: "ltda"}, 2},
+		{"mínimo", repository.ListQuery{MinFuncionarios: &min}, 2},
+		{"faixa", repository.ListQuery{MinFuncionarios: &min, MaxFuncionarios: &max, RazaoSocialContains: "LTDA"}, 1},
+	} {
+		res, err := s.List(ctx, tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(res.Items) != tc.want || res.Total != int64(tc.want) {
+			t.Errorf("%s: %d itens (total %d), want %d", tc.name, len(res.Items), res.Total, tc.want)
+		}
+	}
+}
+
+func testSearch(t *testing.T, s repository.EmpresaStore) {
+	ctx := context.Background()
+	porNome := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[0], NomeFantasia: "Padaria São João", Endereco: "Rua A"})
+	porEndereco := mustCreate(t, s, models.Empresa{CNPJ: cnpjs[1], NomeFantasia: "Mercado", Endereco: "Rua São João, 10"})
+	mustCreate(t, s, models.Empresa{CNPJ: cnpjs[2], NomeFantasia: "Oficina", Endereco: "Av. Brasil"})
+
+	hits, err := s.Search(ctx, "sao joao", 10)
+	if err != nil {
+		t.Fatalf("Search: %v", err)
+	}
+	if len(hits) != 2 {
+		t.Fatalf("Search sem acentos: %d resultados, want 2", len(hits))
+	}
+	if hits[0].ID != porNome || hits[1].ID != porEndereco || hits[0].Score <= hits[1].Score {
+		t.Errorf("Search: ordem por relevância incorreta: %+v", hits)
+	}
+	if hits, err := s.Search(ctx, "PADARIAS", 10); err != nil || len(hits) != 1 {
+		t.Errorf("Search com plural e maiúsculas = %d resultados, %v", len(hits), err)
+	}
+}
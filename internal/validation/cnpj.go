@@ -5,13 +5,113 @@ import (
 	"strings"
 )
 
-// ValidateCNPJ por agora apenas verifica se não é vazio
-// se necessário pode ser incluido validações de CNPJ válido.
+// Erros retornados por ValidateCNPJ. Cada um corresponde a uma regra
+// diferente, permitindo que a camada HTTP informe qual regra falhou.
+var (
+	ErrCNPJObrigatorio = errors.New("cnpj obrigatório")
+	ErrCNPJFormato     = errors.New("cnpj contém caracteres inválidos")
+	ErrCNPJTamanho     = errors.New("cnpj deve conter 14 caracteres")
+	ErrCNPJSequencia   = errors.New("cnpj inválido: sequência de caracteres repetidos")
+	ErrCNPJDigito      = errors.New("cnpj inválido: dígito verificador não confere")
+)
+
+// pesos utilizados no cálculo dos dígitos verificadores (módulo 11).
+var (
+	pesosDV1 = []int{5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}
+	pesosDV2 = []int{6, 5, 4, 3, 2, 9, 8, 7, 6, 5, 4, 3, 2}
+)
+
+// NormalizeCNPJ remove espaços e pontuação (. / -) e converte letras para
+// maiúsculas, produzindo a forma usada para armazenamento e comparação.
+// Não valida o conteúdo; use ValidateCNPJ para isso.
+func NormalizeCNPJ(cnpj string) string {
+	var b strings.Builder
+	b.Grow(14)
+	for _, c := range cnpj {
+		if ehPontuacao(c) {
+			continue
+		}
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// FormatCNPJ formata um CNPJ na máscara XX.XXX.XXX/XXXX-XX para exibição.
+// A entrada é normalizada antes; valores que não têm 14 caracteres após a
+// normalização são devolvidos sem alteração.
+func FormatCNPJ(cnpj string) string {
+	n := NormalizeCNPJ(cnpj)
+	if len(n) != 14 {
+		return cnpj
+	}
+	return n[0:2] + "." + n[2:5] + "." + n[5:8] + "/" + n[8:12] + "-" + n[12:14]
+}
+
+// ValidateCNPJ valida um CNPJ numérico ou alfanumérico (IN RFB 2.229/2024),
+// com ou sem pontuação (ex.: "04.252.011/0001-10", "12.ABC.345/01DE-35").
+// Após a normalização exige 14 caracteres, sendo os 12 primeiros dígitos ou
+// letras e os 2 últimos dígitos; rejeita sequências repetidas
+// (ex.: "00000000000000") e confere os dois dígitos verificadores, calculados
+// pelo módulo 11 sobre o valor ASCII de cada caractere menos 48.
 func ValidateCNPJ(cnpj string) error {
 	cnpj = strings.TrimSpace(cnpj)
 	if cnpj == "" {
-		return errors.New("cnpj obrigatório")
+		return ErrCNPJObrigatorio
 	}
 
+	norm := NormalizeCNPJ(cnpj)
+	for i, c := range norm {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'A' && c <= 'Z' && i < 12:
+		default:
+			return ErrCNPJFormato
+		}
+	}
+	if len(norm) != 14 {
+		return ErrCNPJTamanho
+	}
+	valores := make([]int, 14)
+	for i := 0; i < 14; i++ {
+		valores[i] = int(norm[i] - '0')
+	}
+	if repetido(valores) {
+		return ErrCNPJSequencia
+	}
+	if digitoVerificador(valores[:12], pesosDV1) != valores[12] ||
+		digitoVerificador(valores[:13], pesosDV2) != valores[13] {
+		return ErrCNPJDigito
+	}
 	return nil
 }
+
+// ehPontuacao indica os caracteres aceitos apenas na forma formatada.
+func ehPontuacao(c rune) bool {
+	return c == '.' || c == '/' || c == '-' || c == ' '
+}
+
+// digitoVerificador calcula um dígito verificador pelo módulo 11.
+func digitoVerificador(valores, pesos []int) int {
+	soma := 0
+	for i, v := range valores {
+		soma += v * pesos[i]
+	}
+	resto := soma % 11
+	if resto < 2 {
+		return 0
+	}
+	return 11 - resto
+}
+
+// repetido indica se todos os caracteres são iguais.
+func repetido(valores []int) bool {
+	for _, v := range valores[1:] {
+		if v != valores[0] {
+			return false
+		}
+	}
+	return true
+}
@@ -1,6 +1,9 @@
 package validation
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestValidateCNPJ(t *testing.T) {
 	type args struct {
@@ -9,29 +12,129 @@ func TestValidateCNPJ(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		wantErr bool
+		wantErr error
 	}{
 		{
 			name:    "válido com pontuação",
 			args:    args{cnpj: "04.252.011/0001-10"},
-			wantErr: false,
+			wantErr: nil,
 		},
 		{
 			name:    "válido sem pontuação",
 			args:    args{cnpj: "04252011000110"},
-			wantErr: false,
+			wantErr: nil,
+		},
+		{
+			name:    "válido com espaços nas bordas",
+			args:    args{cnpj: " 11.222.333/0001-81 "},
+			wantErr: nil,
+		},
+		{
+			name:    "alfanumérico com pontuação",
+			args:    args{cnpj: "12.ABC.345/01DE-35"},
+			wantErr: nil,
+		},
+		{
+			name:    "alfanumérico em minúsculas",
+			args:    args{cnpj: "12abc34501de35"},
+			wantErr: nil,
+		},
+		{
+			name:    "alfanumérico com dígito verificador errado",
+			args:    args{cnpj: "12ABC34501DE36"},
+			wantErr: ErrCNPJDigito,
+		},
+		{
+			name:    "letra na posição do dígito verificador",
+			args:    args{cnpj: "12ABC34501DE3A"},
+			wantErr: ErrCNPJFormato,
 		},
 		{
 			name:    "string vazia",
 			args:    args{cnpj: ""},
-			wantErr: true,
+			wantErr: ErrCNPJObrigatorio,
+		},
+		{
+			name:    "caractere inválido",
+			args:    args{cnpj: "04252011#00110"},
+			wantErr: ErrCNPJFormato,
+		},
+		{
+			name:    "dígitos a menos",
+			args:    args{cnpj: "0425201100011"},
+			wantErr: ErrCNPJTamanho,
+		},
+		{
+			name:    "dígitos a mais",
+			args:    args{cnpj: "042520110001100"},
+			wantErr: ErrCNPJTamanho,
+		},
+		{
+			name:    "sequência de zeros",
+			args:    args{cnpj: "00000000000000"},
+			wantErr: ErrCNPJSequencia,
+		},
+		{
+			name:    "sequência repetida formatada",
+			args:    args{cnpj: "11.111.111/1111-11"},
+			wantErr: ErrCNPJSequencia,
+		},
+		{
+			name:    "primeiro dígito verificador errado",
+			args:    args{cnpj: "04252011000120"},
+			wantErr: ErrCNPJDigito,
+		},
+		{
+			name:    "segundo dígito verificador errado",
+			args:    args{cnpj: "04252011000111"},
+			wantErr: ErrCNPJDigito,
+		},
+		{
+			name:    "exemplo antigo sem dígito válido",
+			args:    args{cnpj: "12345678000199"},
+			wantErr: ErrCNPJDigito,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := ValidateCNPJ(tt.args.cnpj); (err != nil) != tt.wantErr {
+			err := ValidateCNPJ(tt.args.cnpj)
+			if !errors.Is(err, tt.wantErr) {
 				t.Errorf("ValidateCNPJ() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestNormalizeCNPJ(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "04.252.011/0001-10", want: "04252011000110"},
+		{in: "04252011000110", want: "04252011000110"},
+		{in: " 12.abc.345/01de-35 ", want: "12ABC34501DE35"},
+		{in: "12ABC34501DE35", want: "12ABC34501DE35"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeCNPJ(tt.in); got != tt.want {
+			t.Errorf("NormalizeCNPJ(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCNPJ(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "04252011000110", want: "04.252.011/0001-10"},
+		{in: "04.252.011/0001-10", want: "04.252.011/0001-10"},
+		{in: "12abc34501de35", want: "12.ABC.345/01DE-35"},
+		{in: "123", want: "123"},
+	}
+	for _, tt := range tests {
+		if got := FormatCNPJ(tt.in); got != tt.want {
+			t.Errorf("FormatCNPJ(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
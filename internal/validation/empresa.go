@@ -0,0 +1,88 @@
+package validation
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"matriz/internal/models"
+)
+
+// Tamanhos máximos (em caracteres) dos campos de texto de models.Empresa.
+const (
+	MaxNomeFantasia = 150
+	MaxRazaoSocial  = 150
+	MaxEndereco     = 300
+)
+
+// FieldError descreve a falha de validação de um campo.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors acumula erros de campo para que sejam devolvidos juntos.
+type Errors []FieldError
+
+// Add registra um erro para o campo informado.
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Has indica se já existe erro registrado para o campo.
+func (e Errors) Has(field string) bool {
+	for _, fe := range e {
+		if fe.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (e Errors) Error() string {
+	parts := make([]string, len(e))
+	for i, fe := range e {
+		parts[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ValidateEmpresa aplica as regras de campo de models.Empresa (exceto CNPJ,
+// ver ValidateCNPJ) e devolve errs acrescido das falhas encontradas.
+// errs contém erros já detectados, por exemplo na conversão de valores
+// numéricos; regras que dependem de um campo já inválido não são aplicadas.
+func ValidateEmpresa(e *models.Empresa, errs Errors) Errors {
+	maxLen(&errs, "nome_fantasia", e.NomeFantasia, MaxNomeFantasia)
+	maxLen(&errs, "razao_social", e.RazaoSocial, MaxRazaoSocial)
+	maxLen(&errs, "endereco", e.Endereco, MaxEndereco)
+
+	funcionariosOK := !errs.Has("num_funcionarios")
+	if funcionariosOK && e.NumFuncionarios < 0 {
+		errs.Add("num_funcionarios", "não pode ser negativo")
+		funcionariosOK = false
+	}
+	naoMaiorQueFuncionarios(&errs, "num_min_pcd", e.NumMinPCD, e.NumFuncionarios, funcionariosOK)
+	naoMaiorQueFuncionarios(&errs, "num_pcd_contratados", e.NumPCDContratados, e.NumFuncionarios, funcionariosOK)
+	return errs
+}
+
+// naoMaiorQueFuncionarios valida uma contagem de empregados que não pode ser
+// negativa nem, quando funcionariosOK, maior que num_funcionarios.
+func naoMaiorQueFuncionarios(errs *Errors, field string, v, funcionarios int, funcionariosOK bool) {
+	if errs.Has(field) {
+		return
+	}
+	switch {
+	case v < 0:
+		errs.Add(field, "não pode ser negativo")
+	case funcionariosOK && v > funcionarios:
+		errs.Add(field, "não pode ser maior que num_funcionarios")
+	}
+}
+
+// maxLen registra erro se value exceder max caracteres.
+func maxLen(errs *Errors, field, value string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		errs.Add(field, "deve ter no máximo "+strconv.Itoa(max)+" caracteres")
+	}
+}
@@ -0,0 +1,69 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"matriz/internal/models"
+)
+
+func TestValidateEmpresa(t *testing.T) {
+	tests := []struct {
+		name       string
+		empresa    models.Empresa
+		prev       Errors
+		wantFields []string
+	}{
+		{
+			name:    "válida",
+			empresa: models.Empresa{NomeFantasia: "Acme", NumFuncionarios: 150, NumMinPCD: 3},
+		},
+		{
+			name:       "números negativos",
+			empresa:    models.Empresa{NumFuncionarios: -1, NumMinPCD: -2},
+			wantFields: []string{"num_funcionarios", "num_min_pcd"},
+		},
+		{
+			name:       "pcd maior que funcionários",
+			empresa:    models.Empresa{NumFuncionarios: 10, NumMinPCD: 11},
+			wantFields: []string{"num_min_pcd"},
+		},
+		{
+			name:       "contratados maior que funcionários",
+			empresa:    models.Empresa{NumFuncionarios: 10, NumPCDContratados: 11},
+			wantFields: []string{"num_pcd_contratados"},
+		},
+		{
+			name: "textos longos",
+			empresa: models.Empresa{
+				NomeFantasia: strings.Repeat("a", MaxNomeFantasia+1),
+				RazaoSocial:  strings.Repeat("b", MaxRazaoSocial+1),
+				Endereco:     strings.Repeat("c", MaxEndereco+1),
+			},
+			wantFields: []string{"nome_fantasia", "razao_social", "endereco"},
+		},
+		{
+			name:    "limite conta caracteres e não bytes",
+			empresa: models.Empresa{NomeFantasia: strings.Repeat("ç", MaxNomeFantasia)},
+		},
+		{
+			name:       "campo já inválido não gera erro derivado",
+			empresa:    models.Empresa{NumFuncionarios: 0, NumMinPCD: 5},
+			prev:       Errors{{Field: "num_funcionarios", Message: "deve ser um número inteiro"}},
+			wantFields: []string{"num_funcionarios"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ValidateEmpresa(&tt.empresa, tt.prev)
+			if len(got) != len(tt.wantFields) {
+				t.Fatalf("ValidateEmpresa() = %v, want fields %v", got, tt.wantFields)
+			}
+			for i, f := range tt.wantFields {
+				if got[i].Field != f {
+					t.Errorf("erro %d no campo %q, want %q", i, got[i].Field, f)
+				}
+			}
+		})
+	}
+}